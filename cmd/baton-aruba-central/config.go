@@ -2,7 +2,12 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
 
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
 	"github.com/conductorone/baton-sdk/pkg/cli"
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc/codes"
@@ -13,7 +18,9 @@ import (
 type config struct {
 	cli.BaseConfig `mapstructure:",squash"` // Puts the base config options in the same place as the connector options
 
+	BaseURL           string `mapstructure:"api-base-url"`
 	BaseHost          string `mapstructure:"api-base-host"`
+	Region            string `mapstructure:"region"`
 	ArubaClientID     string `mapstructure:"aruba-central-client-id"`
 	ArubaClientSecret string `mapstructure:"aruba-central-client-secret"`
 	AccessToken       string `mapstructure:"access-token"`
@@ -21,6 +28,23 @@ type config struct {
 	Username          string `mapstructure:"username"`
 	Password          string `mapstructure:"password"`
 	CustomerID        string `mapstructure:"customer-id"`
+	TokenStorePath    string `mapstructure:"token-store-path"`
+	TokenStoreAgeKey  string `mapstructure:"token-store-age-key"`
+
+	GreenLakeClientID     string `mapstructure:"greenlake-client-id"`
+	GreenLakeClientSecret string `mapstructure:"greenlake-client-secret"`
+	GreenLakeAPIHost      string `mapstructure:"greenlake-api-host"`
+
+	Apps    []string `mapstructure:"apps"`
+	MSPMode bool     `mapstructure:"msp-mode"`
+
+	MaxDailyAPICalls string `mapstructure:"max-daily-api-calls"`
+	APIUsageFile     string `mapstructure:"api-usage-file"`
+
+	AllowGroupScopeNarrowing bool `mapstructure:"allow-group-scope-narrowing"`
+
+	RecordHTTP string `mapstructure:"record-http"`
+	ReplayHTTP string `mapstructure:"replay-http"`
 }
 
 func (cfg *config) ShouldUseOAuth2CodeFlow() bool {
@@ -31,32 +55,251 @@ func (cfg *config) ShouldUseOAuth2RefreshTokenFlow() bool {
 	return cfg.AccessToken != "" && cfg.RefreshToken != ""
 }
 
+func (cfg *config) ShouldUseGreenLake() bool {
+	return cfg.GreenLakeClientID != "" || cfg.GreenLakeClientSecret != ""
+}
+
+// apiBaseURL resolves the API gateway from the explicitly configured base URL or host, or from the region,
+// falling back to the default region.
+func (cfg *config) apiBaseURL() (*url.URL, error) {
+	if cfg.BaseURL != "" && cfg.BaseHost != "" {
+		return nil, status.Errorf(codes.InvalidArgument, "api-base-url and api-base-host can't be set together, use --help for more information")
+	}
+
+	// api-base-host predates api-base-url and takes a bare hostname, which ParseBaseURL accepts as well
+	raw := cfg.BaseURL
+	if raw == "" {
+		raw = cfg.BaseHost
+	}
+
+	var baseURL *url.URL
+	if raw != "" {
+		u, err := arubacentral.ParseBaseURL(raw)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%s", err)
+		}
+
+		baseURL = u
+	}
+
+	regionName := cfg.Region
+	if regionName == "" {
+		if baseURL != nil {
+			return baseURL, nil
+		}
+
+		regionName = arubacentral.DefaultRegion
+	}
+
+	region, ok := arubacentral.LookupRegion(regionName)
+	if !ok {
+		return nil, status.Errorf(
+			codes.InvalidArgument,
+			"unknown region %s, use one of %s",
+			regionName,
+			strings.Join(arubacentral.RegionNames(), ", "),
+		)
+	}
+
+	if baseURL != nil && !strings.EqualFold(baseURL.Hostname(), region.APIHost) {
+		return nil, status.Errorf(
+			codes.InvalidArgument,
+			"api base URL %s doesn't match region %s (%s), set only one of them",
+			baseURL,
+			region.Name,
+			region.APIHost,
+		)
+	}
+
+	if baseURL != nil {
+		return baseURL, nil
+	}
+
+	return &url.URL{Scheme: "https", Host: region.APIHost}, nil
+}
+
+// apiBudget parses max-daily-api-calls, either a number of calls or a percentage of the daily quota like 40%.
+// It returns nil if no budget is set.
+func (cfg *config) apiBudget() (*arubacentral.APIBudget, error) {
+	if cfg.MaxDailyAPICalls == "" {
+		return nil, nil
+	}
+
+	var maxCalls int64
+	var percent float64
+	if p, ok := strings.CutSuffix(cfg.MaxDailyAPICalls, "%"); ok {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || v <= 0 || v > 100 {
+			return nil, status.Errorf(codes.InvalidArgument, "max-daily-api-calls %s must be a percentage between 0 and 100", cfg.MaxDailyAPICalls)
+		}
+
+		percent = v
+	} else {
+		v, err := strconv.ParseInt(cfg.MaxDailyAPICalls, 10, 64)
+		if err != nil || v <= 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "max-daily-api-calls %s must be a positive number of calls or a percentage", cfg.MaxDailyAPICalls)
+		}
+
+		maxCalls = v
+	}
+
+	if cfg.APIUsageFile == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "max-daily-api-calls requires api-usage-file, use --help for more information")
+	}
+
+	return arubacentral.NewAPIBudget(cfg.APIUsageFile, maxCalls, percent)
+}
+
+// greenLakeAPIURL resolves the GreenLake API gateway, which accepts a hostname or a full base URL.
+func (cfg *config) greenLakeAPIURL() (*url.URL, error) {
+	if cfg.GreenLakeAPIHost == "" {
+		return nil, nil
+	}
+
+	u, err := arubacentral.ParseBaseURL(cfg.GreenLakeAPIHost)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err)
+	}
+
+	return u, nil
+}
+
 // validateConfig is run after the configuration is loaded, and should return an error if it isn't valid.
 func validateConfig(ctx context.Context, cfg *config) error {
+	if _, err := cfg.apiBaseURL(); err != nil {
+		return err
+	}
+
+	if _, err := cfg.apiBudget(); err != nil {
+		return err
+	}
+
+	if cfg.RecordHTTP != "" && cfg.ReplayHTTP != "" {
+		return status.Errorf(codes.InvalidArgument, "record-http and replay-http can't be set together")
+	}
+
+	// replayed responses need no credentials
+	if cfg.ReplayHTTP != "" {
+		return nil
+	}
+
+	if cfg.ShouldUseGreenLake() {
+		if _, err := cfg.greenLakeAPIURL(); err != nil {
+			return err
+		}
+
+		if cfg.GreenLakeClientID == "" || cfg.GreenLakeClientSecret == "" {
+			return status.Errorf(codes.InvalidArgument, "greenlake-client-id and greenlake-client-secret are both required, use --help for more information")
+		}
+
+		if cfg.MSPMode {
+			return status.Errorf(codes.InvalidArgument, "msp-mode isn't supported for HPE GreenLake, use --help for more information")
+		}
+
+		return nil
+	}
+
 	if cfg.ArubaClientID == "" || cfg.ArubaClientSecret == "" {
 		return status.Errorf(codes.InvalidArgument, "aruba-central-client-id and aruba-central-client-secret are required, use --help for more information")
 	}
 
 	if !cfg.ShouldUseOAuth2CodeFlow() && !cfg.ShouldUseOAuth2RefreshTokenFlow() {
-		return status.Errorf(codes.InvalidArgument, "either username, password, and customer-id or access-token and refresh-token are required, use --help for more information")
+		return status.Errorf(
+			codes.InvalidArgument,
+			"username, password, and customer-id or access-token and refresh-token are required, "+
+				"or both to fall back to the code flow once the refresh token expires, use --help for more information",
+		)
+	}
+
+	if cfg.TokenStoreAgeKey != "" && cfg.TokenStorePath == "" {
+		return status.Errorf(codes.InvalidArgument, "token-store-age-key requires token-store-path, use --help for more information")
 	}
 
 	return nil
 }
 
 func cmdFlags(cmd *cobra.Command) {
-	// api base host - default region is US West 5 (more information about other regions:
+	// region or api base host - default region is US West 5 (more information about other regions:
 	// https://developer.arubanetworks.com/aruba-central/docs/api-oauth-access-token#table-domain-urls-for-api-gateway-access
-	cmd.PersistentFlags().String("api-base-host", "apigw-uswest5.central.arubanetworks.com", "The base hostname for the Aruba Central API. ($BATON_API_BASE_HOST)")
+	cmd.PersistentFlags().String(
+		"region",
+		"",
+		fmt.Sprintf(
+			"The Aruba Central cluster to connect to, one of %s. Defaults to %s. "+
+				"If it rejects the access token, the API gateways of the other clusters are asked with it whether it belongs to them. "+
+				"($BATON_REGION)",
+			strings.Join(arubacentral.RegionNames(), ", "),
+			arubacentral.DefaultRegion,
+		),
+	)
+	cmd.PersistentFlags().String(
+		"api-base-url",
+		"",
+		"The base URL for the Aruba Central API, with scheme and optional path prefix, "+
+			"for gateways not covered by --region, proxies or local stand-ins. ($BATON_API_BASE_URL)",
+	)
+	cmd.PersistentFlags().String("api-base-host", "", "The base hostname for the Aruba Central API, reached over https. Prefer --api-base-url. ($BATON_API_BASE_HOST)")
 	cmd.PersistentFlags().String("aruba-central-client-id", "", "The client ID of the OAuth2 application for the Aruba Central API. ($BATON_ARUBA_CENTRAL_CLIENT_ID)")
 	cmd.PersistentFlags().String("aruba-central-client-secret", "", "The client secret of the OAuth2 application for the Aruba Central API. ($BATON_ARUBA_CENTRAL_CLIENT_SECRET)")
 
 	// OAuth2 Refresh token flow
 	cmd.PersistentFlags().String("access-token", "", "The access token for the Aruba Central API to be used with refresh token flow. ($BATON_ACCESS_TOKEN)")
 	cmd.PersistentFlags().String("refresh-token", "", "The refresh token for the Aruba Central API to be used with refresh token flow. ($BATON_REFRESH_TOKEN)")
+	cmd.PersistentFlags().String(
+		"token-store-path",
+		"",
+		"The file to persist refreshed tokens to, so they survive restarts. "+
+			"A stored token takes precedence over --access-token and --refresh-token until a different --refresh-token is configured. "+
+			"($BATON_TOKEN_STORE_PATH)",
+	)
+	cmd.PersistentFlags().String(
+		"token-store-age-key",
+		"",
+		"The age secret key (AGE-SECRET-KEY-1...) to encrypt the token store with. The token store is plain JSON if unset. "+
+			"($BATON_TOKEN_STORE_AGE_KEY)",
+	)
 
 	// OAuth2 Code flow
 	cmd.PersistentFlags().String("username", "", "The username for the Aruba Central API to be used with code flow. ($BATON_USERNAME)")
 	cmd.PersistentFlags().String("password", "", "The password for the Aruba Central API to be used with code flow. ($BATON_PASSWORD)")
 	cmd.PersistentFlags().String("customer-id", "", "The customer ID for the Aruba Central API to be used with code flow. ($BATON_CUSTOMER_ID)")
+
+	// HPE GreenLake client credentials flow, for tenants migrated to GreenLake
+	cmd.PersistentFlags().String("greenlake-client-id", "", "The client ID of the HPE GreenLake API client, for tenants migrated to GreenLake. ($BATON_GREENLAKE_CLIENT_ID)")
+	cmd.PersistentFlags().String("greenlake-client-secret", "", "The client secret of the HPE GreenLake API client. ($BATON_GREENLAKE_CLIENT_SECRET)")
+	cmd.PersistentFlags().String("greenlake-api-host", arubacentral.GreenLakeAPIHost, "The hostname or base URL of the HPE GreenLake API. ($BATON_GREENLAKE_API_HOST)")
+
+	// Sync
+	cmd.PersistentFlags().StringSlice("apps", nil, "Limit syncing to the given Aruba Central applications, for example nms or account_setting. All applications are synced by default. ($BATON_APPS)")
+	cmd.PersistentFlags().Bool(
+		"msp-mode",
+		false,
+		"Sync the customers of a Managed Service Provider account as tenants, "+
+			"with users, roles and groups of every customer under its tenant. ($BATON_MSP_MODE)",
+	)
+	cmd.PersistentFlags().String(
+		"max-daily-api-calls",
+		"",
+		"Stop the sync once this many API calls were made today (UTC), or this percentage of the daily quota like 40%. "+
+			"The next run resumes the sync. ($BATON_MAX_DAILY_API_CALLS)",
+	)
+	cmd.PersistentFlags().String(
+		"api-usage-file",
+		"baton-aruba-central-api-usage.json",
+		"The file tracking the API calls made today across runs, used with --max-daily-api-calls. ($BATON_API_USAGE_FILE)",
+	)
+
+	// Provisioning
+	cmd.PersistentFlags().Bool(
+		"allow-group-scope-narrowing",
+		false,
+		"Allow revoking a group from users scoped to all groups by narrowing their scope to the remaining groups. "+
+			"($BATON_ALLOW_GROUP_SCOPE_NARROWING)",
+	)
+
+	// Debugging, hidden as they are meant for bug reports
+	cmd.PersistentFlags().String("record-http", "", "Record scrubbed API responses to the given file. ($BATON_RECORD_HTTP)")
+	cmd.PersistentFlags().String("replay-http", "", "Replay API responses from the given file instead of calling the API. ($BATON_REPLAY_HTTP)")
+	_ = cmd.PersistentFlags().MarkHidden("record-http")
+	_ = cmd.PersistentFlags().MarkHidden("replay-http")
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
 	"github.com/conductorone/baton-aruba-central/pkg/connector"
 	"github.com/conductorone/baton-sdk/pkg/cli"
 	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
@@ -37,36 +38,84 @@ func main() {
 
 func getConnector(ctx context.Context, cfg *config) (types.ConnectorServer, error) {
 	var oauthConfig connector.OAuthConfig
-	var err error
+
+	baseURL, err := cfg.apiBaseURL()
+	if err != nil {
+		return nil, err
+	}
 
 	base := connector.BaseConfig{
-		BaseHost:     cfg.BaseHost,
+		BaseURL:      baseURL,
 		ClientID:     cfg.ArubaClientID,
 		ClientSecret: cfg.ArubaClientSecret,
 	}
 
-	switch {
-	case cfg.ShouldUseOAuth2CodeFlow():
-		oauthConfig = &connector.CodeFlowConfig{
+	var codeFlowConfig *connector.CodeFlowConfig
+	if cfg.ShouldUseOAuth2CodeFlow() {
+		codeFlowConfig = &connector.CodeFlowConfig{
 			BaseConfig: base,
 			Username:   cfg.Username,
 			Password:   cfg.Password,
 			CustomerID: cfg.CustomerID,
 		}
+	}
 
+	switch {
+	case cfg.ShouldUseGreenLake():
+		apiURL, err := cfg.greenLakeAPIURL()
+		if err != nil {
+			return nil, err
+		}
+
+		oauthConfig = &connector.GreenLakeConfig{
+			ClientID:     cfg.GreenLakeClientID,
+			ClientSecret: cfg.GreenLakeClientSecret,
+			APIURL:       apiURL,
+		}
+
+	// with both credential sets configured, the code flow takes over once the refresh token is rejected
 	case cfg.ShouldUseOAuth2RefreshTokenFlow():
+		tokenStore, err := newTokenStore(cfg)
+		if err != nil {
+			return nil, err
+		}
+
 		oauthConfig = &connector.RefreshTokenFlowConfig{
 			BaseConfig:   base,
 			AccessToken:  cfg.AccessToken,
 			RefreshToken: cfg.RefreshToken,
+			TokenStore:   tokenStore,
+			CodeFlow:     codeFlowConfig,
 		}
 
+	case codeFlowConfig != nil:
+		oauthConfig = codeFlowConfig
+
 	default:
 		oauthConfig = &connector.NoConfig{}
 	}
 
+	switch {
+	case cfg.RecordHTTP != "":
+		oauthConfig = &connector.FixtureConfig{OAuthConfig: oauthConfig, Mode: arubacentral.FixtureRecord, Path: cfg.RecordHTTP}
+	case cfg.ReplayHTTP != "":
+		oauthConfig = &connector.FixtureConfig{OAuthConfig: oauthConfig, Mode: arubacentral.FixtureReplay, Path: cfg.ReplayHTTP}
+	}
+
+	budget, err := cfg.apiBudget()
+	if err != nil {
+		return nil, err
+	}
+
 	l := ctxzap.Extract(ctx)
-	cb, err := connector.New(ctx, cfg.BaseHost, oauthConfig)
+	cb, err := connector.New(ctx, connector.Config{
+		BaseURL:             baseURL,
+		OAuth:               oauthConfig,
+		AllowScopeNarrowing: cfg.AllowGroupScopeNarrowing,
+		Apps:                cfg.Apps,
+		MSPMode:             cfg.MSPMode,
+		Budget:              budget,
+	})
 	if err != nil {
 		l.Error("error creating connector", zap.Error(err))
 		return nil, err
@@ -80,3 +129,15 @@ func getConnector(ctx context.Context, cfg *config) (types.ConnectorServer, erro
 
 	return c, nil
 }
+
+// newTokenStore returns the configured token store, or nil if tokens shouldn't be persisted.
+func newTokenStore(cfg *config) (connector.TokenStore, error) {
+	switch {
+	case cfg.TokenStorePath == "":
+		return nil, nil
+	case cfg.TokenStoreAgeKey != "":
+		return connector.NewAgeTokenStore(cfg.TokenStorePath, cfg.TokenStoreAgeKey)
+	default:
+		return connector.NewFileTokenStore(cfg.TokenStorePath), nil
+	}
+}
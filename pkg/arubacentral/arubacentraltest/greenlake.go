@@ -0,0 +1,326 @@
+package arubacentraltest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
+)
+
+// GreenLakeTokenPath is the client credentials token endpoint of HPE GreenLake SSO.
+const GreenLakeTokenPath = "/as/token.oauth2" // #nosec G101 (hardcoded credentials are not used here)
+
+// handleGreenLake serves the GreenLake identity and authorization APIs of a tenant migrated to GreenLake.
+// They share the account's users and roles with the Central endpoints, mapped onto the GreenLake models.
+func (s *Server) handleGreenLake(mux, api *http.ServeMux) {
+	mux.HandleFunc("POST "+GreenLakeTokenPath, s.greenLakeToken)
+
+	api.HandleFunc("GET "+arubacentral.GreenLakeUsersEndpoint, s.listGreenLakeUsers)
+	api.HandleFunc("POST "+arubacentral.GreenLakeUsersEndpoint, s.createGreenLakeUser)
+	api.HandleFunc("DELETE "+arubacentral.GreenLakeUsersEndpoint+"/{id}", s.deleteGreenLakeUser)
+	api.HandleFunc("GET "+arubacentral.GreenLakeUsersEndpoint+"/{id}/"+arubacentral.GreenLakeRoleAssignmentsPath, s.getGreenLakeRoleAssignments)
+	api.HandleFunc("PUT "+arubacentral.GreenLakeUsersEndpoint+"/{id}/"+arubacentral.GreenLakeRoleAssignmentsPath, s.setGreenLakeRoleAssignments)
+	api.HandleFunc("GET "+arubacentral.GreenLakeApplicationsEndpoint, s.listGreenLakeApplications)
+	api.HandleFunc("GET "+arubacentral.GreenLakeApplicationsEndpoint+"/{app}/roles", s.listGreenLakeRoles)
+	api.HandleFunc("GET "+arubacentral.GreenLakeApplicationsEndpoint+"/{app}/roles/{role}", s.getGreenLakeRole)
+}
+
+// GreenLakeUserID returns the GreenLake id of the user.
+func GreenLakeUserID(username string) string {
+	sum := sha256.Sum256([]byte(username))
+
+	return hex.EncodeToString(sum[:8])
+}
+
+// greenLakeToken serves the client credentials grant, sent as a form with the client credentials in it.
+func (s *Server) greenLakeToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "client_credentials" {
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type", fmt.Sprintf("Unsupported grant type %s", r.PostForm.Get("grant_type")))
+		return
+	}
+
+	if r.PostForm.Get("client_id") != s.Credentials.ClientID || r.PostForm.Get("client_secret") != s.Credentials.ClientSecret {
+		writeError(w, http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
+		return
+	}
+
+	s.mu.Lock()
+	accessToken := randomToken()
+	s.accessTokens[accessToken] = true
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   tokenLifetime,
+	})
+}
+
+// listGreenLakeUsers lists users, or finds them by username with a filter like username eq 'user@example.com'.
+func (s *Server) listGreenLakeUsers(w http.ResponseWriter, r *http.Request) {
+	var username string
+	filter := r.URL.Query().Get("filter")
+	if filter != "" {
+		value, ok := strings.CutPrefix(filter, "username eq '")
+		if !ok || !strings.HasSuffix(value, "'") {
+			writeGreenLakeError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported filter %s", filter))
+			return
+		}
+
+		username = strings.ReplaceAll(strings.TrimSuffix(value, "'"), "''", "'")
+	}
+
+	s.mu.Lock()
+	t := s.tenant(r)
+	var users []arubacentral.GreenLakeUser
+	for _, user := range t.users {
+		if filter == "" || strings.EqualFold(user.Username, username) {
+			users = append(users, greenLakeUser(&user)) // #nosec G601
+		}
+	}
+	s.mu.Unlock()
+
+	if filter != "" {
+		s.writeAPI(w, http.StatusOK, arubacentral.ListResponse[arubacentral.GreenLakeUser]{Items: users, Total: uint(len(users))})
+		return
+	}
+
+	limit, offset, ok := s.page(w, r)
+	if !ok {
+		return
+	}
+
+	items, total := paginate(users, limit, offset)
+	s.writeAPI(w, http.StatusOK, arubacentral.ListResponse[arubacentral.GreenLakeUser]{Items: items, Total: total})
+}
+
+// createGreenLakeUser invites a user, who starts out without any role assignment.
+func (s *Server) createGreenLakeUser(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Email == "" {
+		writeGreenLakeError(w, http.StatusBadRequest, "Invalid user")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.tenant(r)
+	if t.userIndex(body.Email) >= 0 {
+		writeGreenLakeError(w, http.StatusConflict, fmt.Sprintf("User %s already exists", body.Email))
+		return
+	}
+
+	user := arubacentral.User{Username: body.Email}
+	t.users = append(t.users, user)
+	s.writeAPI(w, http.StatusOK, greenLakeUser(&user))
+}
+
+func (s *Server) deleteGreenLakeUser(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.tenant(r)
+	i, ok := t.greenLakeUserIndex(w, r)
+	if !ok {
+		return
+	}
+
+	t.users = slices.Delete(t.users, i, i+1)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getGreenLakeRoleAssignments(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.tenant(r)
+	i, ok := t.greenLakeUserIndex(w, r)
+	if !ok {
+		return
+	}
+
+	// assignments scoped to all groups carry no scope groups
+	assignments := []arubacentral.GreenLakeRoleAssignment{}
+	for _, app := range t.users[i].Applications {
+		for _, info := range app.Info {
+			assignment := arubacentral.GreenLakeRoleAssignment{RoleName: info.Role, ApplicationName: app.Name}
+			if !info.Scope.HasAllGroups() {
+				assignment.ScopeGroups = info.Scope.Groups
+			}
+
+			assignments = append(assignments, assignment)
+		}
+	}
+
+	s.writeAPI(w, http.StatusOK, arubacentral.ListResponse[arubacentral.GreenLakeRoleAssignment]{Items: assignments, Total: uint(len(assignments))})
+}
+
+// setGreenLakeRoleAssignments replaces the role assignments of the user.
+func (s *Server) setGreenLakeRoleAssignments(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Items []arubacentral.GreenLakeRoleAssignment `json:"items"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeGreenLakeError(w, http.StatusBadRequest, "Invalid role assignments")
+		return
+	}
+
+	var apps []arubacentral.UserApplication
+	for _, assignment := range body.Items {
+		scope := arubacentral.UserScope{Groups: assignment.ScopeGroups}
+		if len(scope.Groups) == 0 {
+			scope.Groups = []string{arubacentral.AllGroupsScope}
+		}
+
+		info := arubacentral.UserRoleInfo{Role: assignment.RoleName, Scope: scope}
+		i := slices.IndexFunc(apps, func(app arubacentral.UserApplication) bool {
+			return app.Name == assignment.ApplicationName
+		})
+		if i < 0 {
+			apps = append(apps, arubacentral.UserApplication{Name: assignment.ApplicationName})
+			i = len(apps) - 1
+		}
+
+		apps[i].Info = append(apps[i].Info, info)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.tenant(r)
+	i, ok := t.greenLakeUserIndex(w, r)
+	if !ok {
+		return
+	}
+
+	if msg, ok := t.validAssignments(apps); !ok {
+		writeGreenLakeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	t.users[i].Applications = apps
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listGreenLakeApplications(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := s.page(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	t := s.tenant(r)
+	apps := make([]arubacentral.GreenLakeApplication, 0, len(t.apps))
+	for _, app := range t.apps {
+		apps = append(apps, arubacentral.GreenLakeApplication{Name: app})
+	}
+	s.mu.Unlock()
+
+	items, total := paginate(apps, limit, offset)
+	s.writeAPI(w, http.StatusOK, arubacentral.ListResponse[arubacentral.GreenLakeApplication]{Items: items, Total: total})
+}
+
+func (s *Server) listGreenLakeRoles(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := s.page(w, r)
+	if !ok {
+		return
+	}
+
+	appName := r.PathValue("app")
+
+	s.mu.Lock()
+	t := s.tenant(r)
+	if !slices.Contains(t.apps, appName) {
+		s.mu.Unlock()
+		writeGreenLakeError(w, http.StatusNotFound, fmt.Sprintf("Application %s not found", appName))
+		return
+	}
+
+	roles := make([]arubacentral.GreenLakeRole, 0, len(t.roles[appName]))
+	for _, role := range t.roles[appName] {
+		roles = append(roles, greenLakeRole(appName, &role)) // #nosec G601
+	}
+	s.mu.Unlock()
+
+	items, total := paginate(roles, limit, offset)
+	s.writeAPI(w, http.StatusOK, arubacentral.ListResponse[arubacentral.GreenLakeRole]{Items: items, Total: total})
+}
+
+func (s *Server) getGreenLakeRole(w http.ResponseWriter, r *http.Request) {
+	appName, roleName := r.PathValue("app"), r.PathValue("role")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.tenant(r)
+	i := t.roleIndex(appName, roleName)
+	if i < 0 {
+		writeGreenLakeError(w, http.StatusNotFound, fmt.Sprintf("Role %s not found", roleName))
+		return
+	}
+
+	s.writeAPI(w, http.StatusOK, greenLakeRole(appName, &t.roles[appName][i]))
+}
+
+// greenLakeUserIndex returns the position of the user addressed by the id path value,
+// writing a not found error if there's none. It must be called with the lock held.
+func (t *Tenant) greenLakeUserIndex(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id := r.PathValue("id")
+	i := slices.IndexFunc(t.users, func(user arubacentral.User) bool {
+		return GreenLakeUserID(user.Username) == id
+	})
+	if i < 0 {
+		writeGreenLakeError(w, http.StatusNotFound, fmt.Sprintf("User %s not found", id))
+		return 0, false
+	}
+
+	return i, true
+}
+
+func greenLakeUser(user *arubacentral.User) arubacentral.GreenLakeUser {
+	return arubacentral.GreenLakeUser{
+		ID:         GreenLakeUserID(user.Username),
+		Username:   user.Username,
+		FirstName:  user.Name.First,
+		LastName:   user.Name.Last,
+		UserStatus: "VERIFIED",
+	}
+}
+
+// greenLakeRole lists the modules of the role as its permissions.
+func greenLakeRole(appName string, role *arubacentral.Role) arubacentral.GreenLakeRole {
+	permissions := []string{}
+	for _, app := range role.Applications {
+		for _, module := range app.Modules {
+			permissions = append(permissions, module.Name)
+		}
+	}
+
+	return arubacentral.GreenLakeRole{
+		Name:            role.RoleName,
+		ApplicationName: appName,
+		Predefined:      arubacentral.IsSystemRole(role.RoleName),
+		Permissions:     permissions,
+	}
+}
+
+// writeGreenLakeError writes the error body of the GreenLake APIs.
+func writeGreenLakeError(w http.ResponseWriter, statusCode int, message string) {
+	writeJSON(w, statusCode, map[string]string{
+		"errorCode": fmt.Sprintf("HPE_GL_ERROR_%d", statusCode),
+		"message":   message,
+	})
+}
@@ -0,0 +1,981 @@
+// Package arubacentraltest provides an in-memory fake of the Aruba Central API gateway for tests.
+// It implements the OAuth code and refresh token flows, the RBAC users, roles and apps endpoints,
+// groups, sites and labels, with limit/offset pagination, X-Ratelimit-* headers and Central's error bodies.
+// MSP customers are served as tenants with data of their own, addressed by the TenantID header.
+// The HPE GreenLake token, identity and authorization endpoints serve the same users and roles
+// to tenants migrated to GreenLake.
+package arubacentraltest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
+)
+
+const (
+	LoginPath    = "/oauth2/authorize/central/api/login"
+	AuthCodePath = "/oauth2/authorize/central/api"
+	TokenPath    = "/oauth2/token" // #nosec G101 (hardcoded credentials are not used here)
+
+	// DefaultDailyLimit and DefaultSecondLimit are the default API quotas of a Central account.
+	DefaultDailyLimit  = 5000
+	DefaultSecondLimit = 7
+
+	// tokenLifetime is the expires_in of issued access tokens, in seconds.
+	tokenLifetime = 7200
+
+	sessionCookie = "session"
+	csrfCookie    = "X-CSRF-TOKEN"
+)
+
+// maxLimits are the largest page sizes the endpoints honour. Larger limits are silently clamped like Central does.
+var maxLimits = map[string]int{
+	arubacentral.UsersEndpoint:  1000,
+	arubacentral.RolesEndpoint:  1000,
+	arubacentral.AppsEndpoint:   1000,
+	arubacentral.GroupsEndpoint: 20,
+	arubacentral.SitesEndpoint:  1000,
+	arubacentral.LabelsEndpoint: 1000,
+
+	arubacentral.MSPCustomersEndpoint: 50,
+}
+
+// Credentials are the credentials accepted by the server.
+type Credentials struct {
+	ClientID     string
+	ClientSecret string
+	Username     string
+	Password     string
+	CustomerID   string
+}
+
+// DefaultCredentials are used by NewServer.
+var DefaultCredentials = Credentials{
+	ClientID:     "client-id",
+	ClientSecret: "client-secret",
+	Username:     "admin@example.com",
+	Password:     "password",
+	CustomerID:   "customer-id",
+}
+
+// Server is a fake Aruba Central API gateway. It is safe for concurrent use.
+// The methods of the embedded Tenant act on the account itself.
+type Server struct {
+	*httptest.Server
+	*Tenant
+
+	Credentials Credentials
+
+	mu        sync.Mutex
+	customers []arubacentral.Customer
+	tenants   map[string]*Tenant
+
+	accessTokens  map[string]bool
+	refreshTokens map[string]bool
+	authCodes     map[string]bool
+	// sessions maps session cookies to their CSRF token.
+	sessions map[string]string
+
+	dailyLimit int
+	usedToday  int
+	failures   map[string][]int
+	requests   map[string]int
+}
+
+// NewServer starts a plain HTTP server accepting DefaultCredentials. It is closed when the test finishes.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	s := &Server{
+		Credentials:   DefaultCredentials,
+		tenants:       make(map[string]*Tenant),
+		accessTokens:  make(map[string]bool),
+		refreshTokens: make(map[string]bool),
+		authCodes:     make(map[string]bool),
+		sessions:      make(map[string]string),
+		dailyLimit:    DefaultDailyLimit,
+		failures:      make(map[string][]int),
+		requests:      make(map[string]int),
+	}
+
+	s.Tenant = newTenant(&s.mu)
+	s.Server = httptest.NewServer(s.handler())
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// BaseURL returns the base URL of the server, to be passed to the connector.
+func (s *Server) BaseURL() *url.URL {
+	u, _ := url.Parse(s.URL)
+
+	return u
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST "+LoginPath, s.login)
+	mux.HandleFunc("POST "+AuthCodePath, s.authCode)
+	mux.HandleFunc("POST "+TokenPath, s.token)
+
+	api := http.NewServeMux()
+	api.HandleFunc("GET "+arubacentral.UsersEndpoint, s.listUsers)
+	api.HandleFunc("POST "+arubacentral.UsersEndpoint, s.createUser)
+	api.HandleFunc("GET "+arubacentral.UsersEndpoint+"/{username}", s.getUser)
+	api.HandleFunc("PATCH "+arubacentral.UsersEndpoint+"/{username}", s.updateUser)
+	api.HandleFunc("DELETE "+arubacentral.UsersEndpoint+"/{username}", s.deleteUser)
+	api.HandleFunc("GET "+arubacentral.AppsEndpoint, s.listApps)
+	api.HandleFunc("GET "+arubacentral.RolesEndpoint, s.listRoles)
+	api.HandleFunc("POST "+arubacentral.AppsEndpoint+"/{app}/roles", s.createRole)
+	api.HandleFunc("GET "+arubacentral.AppsEndpoint+"/{app}/roles/{role}", s.getRole)
+	api.HandleFunc("PATCH "+arubacentral.AppsEndpoint+"/{app}/roles/{role}", s.updateRole)
+	api.HandleFunc("DELETE "+arubacentral.AppsEndpoint+"/{app}/roles/{role}", s.deleteRole)
+	api.HandleFunc("GET "+arubacentral.GroupsEndpoint, s.listGroups)
+	api.HandleFunc("GET "+arubacentral.SitesEndpoint, s.listSites)
+	api.HandleFunc("GET "+arubacentral.LabelsEndpoint, s.listLabels)
+	api.HandleFunc("GET "+arubacentral.MSPCustomersEndpoint, s.listCustomers)
+	s.handleGreenLake(mux, api)
+
+	mux.Handle("/", s.gateway(api))
+
+	return s.count(mux)
+}
+
+// count tracks requests per path and serves queued failures before the request reaches its handler.
+func (s *Server) count(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests[r.URL.Path]++
+		var failure int
+		if queued := s.failures[r.URL.Path]; len(queued) > 0 {
+			failure, s.failures[r.URL.Path] = queued[0], queued[1:]
+		}
+		s.mu.Unlock()
+
+		if failure != 0 {
+			s.fail(w, failure)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// gateway authorizes API requests and enforces the daily quota, like the Central API gateway in front of the services.
+// Requests on behalf of a tenant must name a customer of the account.
+func (s *Server) gateway(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		authorized := s.accessTokens[bearerToken(r)]
+		exhausted := authorized && s.usedToday >= s.dailyLimit
+		if authorized && !exhausted {
+			s.usedToday++
+		}
+		limit, remaining := s.dailyLimit, s.dailyLimit-s.usedToday
+		tenantID := r.Header.Get(arubacentral.TenantIDHeader)
+		_, customer := s.tenants[tenantID]
+		s.mu.Unlock()
+
+		if !authorized {
+			s.fail(w, http.StatusUnauthorized)
+			return
+		}
+
+		if exhausted {
+			s.fail(w, http.StatusTooManyRequests)
+			return
+		}
+
+		setRatelimitHeaders(w, limit, remaining, DefaultSecondLimit-1)
+		if tenantID != "" && !customer {
+			s.writeServiceError(w, http.StatusForbidden, fmt.Sprintf("Customer %s is not managed by this MSP", tenantID))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Fail makes the next requests to the path fail with the given status codes, one per request,
+// with the error body Central sends for that status.
+func (s *Server) Fail(path string, statusCodes ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures[path] = append(s.failures[path], statusCodes...)
+}
+
+// Requests returns the number of requests received for the path.
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[path]
+}
+
+// SetDailyLimit sets the daily API quota and resets the calls counted against it.
+func (s *Server) SetDailyLimit(limit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dailyLimit = limit
+	s.usedToday = 0
+}
+
+// UsedToday returns the calls counted against the daily quota.
+func (s *Server) UsedToday() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.usedToday
+}
+
+// IssueToken issues an access and refresh token pair, as downloaded from the API gateway UI.
+func (s *Server) IssueToken() (string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.issueToken()
+}
+
+// RevokeAccessTokens invalidates all issued access tokens, refresh tokens stay valid.
+func (s *Server) RevokeAccessTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clear(s.accessTokens)
+}
+
+// RevokeRefreshTokens invalidates all issued refresh tokens, as happens when they expire after 14 days.
+func (s *Server) RevokeRefreshTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clear(s.refreshTokens)
+}
+
+// Tenant holds the data of the account or of one of its MSP customers.
+type Tenant struct {
+	// mu is the lock of the server the tenant belongs to.
+	mu *sync.Mutex
+
+	users  []arubacentral.User
+	apps   []string
+	roles  map[string][]arubacentral.Role
+	groups []string
+	sites  []arubacentral.Site
+	labels []arubacentral.Label
+}
+
+func newTenant(mu *sync.Mutex) *Tenant {
+	return &Tenant{
+		mu:    mu,
+		roles: make(map[string][]arubacentral.Role),
+	}
+}
+
+// AddCustomer adds a customer to the account, making it an MSP, and returns the customer's tenant.
+func (s *Server) AddCustomer(customer arubacentral.Customer) *Tenant {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := newTenant(&s.mu)
+	s.customers = append(s.customers, customer)
+	s.tenants[customer.ID] = t
+
+	return t
+}
+
+// tenant returns the tenant the request is made on behalf of, the account itself without a TenantID header.
+// The gateway already refused unknown tenants. It must be called with the lock held.
+func (s *Server) tenant(r *http.Request) *Tenant {
+	if t, ok := s.tenants[r.Header.Get(arubacentral.TenantIDHeader)]; ok {
+		return t
+	}
+
+	return s.Tenant
+}
+
+func (t *Tenant) AddUsers(users ...arubacentral.User) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.users = append(t.users, users...)
+}
+
+// User returns the current state of a user.
+func (t *Tenant) User(username string) (arubacentral.User, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	i := t.userIndex(username)
+	if i < 0 {
+		return arubacentral.User{}, false
+	}
+
+	return t.users[i], true
+}
+
+// AddRoles adds the roles to the application, adding the application if it doesn't exist yet.
+func (t *Tenant) AddRoles(appName string, roles ...arubacentral.Role) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !slices.Contains(t.apps, appName) {
+		t.apps = append(t.apps, appName)
+	}
+
+	t.roles[appName] = append(t.roles[appName], roles...)
+}
+
+// Role returns the current state of a role, with the users holding it.
+func (t *Tenant) Role(appName, roleName string) (arubacentral.Role, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	i := t.roleIndex(appName, roleName)
+	if i < 0 {
+		return arubacentral.Role{}, false
+	}
+
+	return t.withUsers(appName, t.roles[appName][i]), true
+}
+
+func (t *Tenant) AddGroups(groups ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.groups = append(t.groups, groups...)
+}
+
+func (t *Tenant) AddSites(sites ...arubacentral.Site) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sites = append(t.sites, sites...)
+}
+
+func (t *Tenant) AddLabels(labels ...arubacentral.Label) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.labels = append(t.labels, labels...)
+}
+
+// issueToken must be called with the lock held.
+func (s *Server) issueToken() (string, string) {
+	accessToken, refreshToken := randomToken(), randomToken()
+	s.accessTokens[accessToken] = true
+	s.refreshTokens[refreshToken] = true
+
+	return accessToken, refreshToken
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"message": "Invalid request body", "status": false})
+		return
+	}
+
+	if r.URL.Query().Get("client_id") != s.Credentials.ClientID || body.Username != s.Credentials.Username || body.Password != s.Credentials.Password {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"message": "Invalid client_id or user credentials", "status": false})
+		return
+	}
+
+	session, csrf := randomToken(), randomToken()
+	s.mu.Lock()
+	s.sessions[session] = csrf
+	s.mu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: session, Path: "/", HttpOnly: true})
+	http.SetCookie(w, &http.Cookie{Name: csrfCookie, Value: csrf, Path: "/"})
+	writeJSON(w, http.StatusOK, map[string]interface{}{"extra": map[string]string{"message": "login successful"}, "status": true})
+}
+
+func (s *Server) authCode(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		CustomerID string `json:"customer_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+
+	q := r.URL.Query()
+	if q.Get("client_id") != s.Credentials.ClientID || q.Get("response_type") != "code" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid client_id or response_type")
+		return
+	}
+
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Login required")
+		return
+	}
+
+	s.mu.Lock()
+	csrf, ok := s.sessions[cookie.Value]
+	s.mu.Unlock()
+
+	if !ok || r.Header.Get(csrfCookie) != csrf {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Invalid session or CSRF token")
+		return
+	}
+
+	if body.CustomerID != s.Credentials.CustomerID {
+		writeError(w, http.StatusForbidden, "access_denied", "User doesn't belong to customer")
+		return
+	}
+
+	code := randomToken()
+	s.mu.Lock()
+	s.authCodes[code] = true
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]string{"auth_code": code})
+}
+
+// token serves both grants. The refresh token grant is sent as query parameters and the authorization code grant
+// as a JSON body, matching what Central accepts.
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		GrantType    string `json:"grant_type"`
+		Code         string `json:"code"`
+	}
+
+	q := r.URL.Query()
+	if q.Get("grant_type") != "" {
+		req.ClientID, req.ClientSecret, req.GrantType = q.Get("client_id"), q.Get("client_secret"), q.Get("grant_type")
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+
+	if req.ClientID != s.Credentials.ClientID || req.ClientSecret != s.Credentials.ClientSecret {
+		writeError(w, http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch req.GrantType {
+	case "refresh_token":
+		refreshToken := q.Get("refresh_token")
+		if !s.refreshTokens[refreshToken] {
+			writeError(w, http.StatusBadRequest, "invalid_grant", "Invalid refresh token")
+			return
+		}
+
+		// refresh tokens rotate, the used one stops working
+		delete(s.refreshTokens, refreshToken)
+
+	case "authorization_code":
+		if !s.authCodes[req.Code] {
+			writeError(w, http.StatusBadRequest, "invalid_grant", "Invalid authorization code")
+			return
+		}
+
+		delete(s.authCodes, req.Code)
+
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type", fmt.Sprintf("Unsupported grant type %s", req.GrantType))
+		return
+	}
+
+	accessToken, refreshToken := s.issueToken()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+		"token_type":    "bearer",
+		"expires_in":    tokenLifetime,
+	})
+}
+
+func (s *Server) listUsers(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := s.page(w, r)
+	if !ok {
+		return
+	}
+
+	appName := r.URL.Query().Get("app_name")
+
+	s.mu.Lock()
+	t := s.tenant(r)
+	var users []arubacentral.User
+	for _, user := range t.users {
+		if appName == "" || slices.ContainsFunc(user.Applications, func(app arubacentral.UserApplication) bool {
+			return app.Name == appName
+		}) {
+			users = append(users, user)
+		}
+	}
+	s.mu.Unlock()
+
+	items, total := paginate(users, limit, offset)
+	s.writeAPI(w, http.StatusOK, arubacentral.ListResponse[arubacentral.User]{Items: items, Total: total})
+}
+
+func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+
+	s.mu.Lock()
+	t := s.tenant(r)
+	defer s.mu.Unlock()
+
+	i := t.userIndex(username)
+	if i < 0 {
+		s.writeServiceError(w, http.StatusNotFound, fmt.Sprintf("User %s not found", username))
+		return
+	}
+
+	s.writeAPI(w, http.StatusOK, t.users[i])
+}
+
+func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
+	var user arubacentral.User
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil || user.Username == "" {
+		s.writeServiceError(w, http.StatusBadRequest, "Invalid user")
+		return
+	}
+
+	s.mu.Lock()
+	t := s.tenant(r)
+	defer s.mu.Unlock()
+
+	if t.userIndex(user.Username) >= 0 {
+		s.writeServiceError(w, http.StatusConflict, fmt.Sprintf("User %s already exists", user.Username))
+		return
+	}
+
+	if msg, ok := t.validAssignments(user.Applications); !ok {
+		s.writeServiceError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	t.users = append(t.users, user)
+	s.writeAPI(w, http.StatusOK, map[string]string{"message": "User created"})
+}
+
+func (s *Server) updateUser(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+
+	var body arubacentral.UpdateUserBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.writeServiceError(w, http.StatusBadRequest, "Invalid user")
+		return
+	}
+
+	s.mu.Lock()
+	t := s.tenant(r)
+	defer s.mu.Unlock()
+
+	i := t.userIndex(username)
+	if i < 0 {
+		s.writeServiceError(w, http.StatusNotFound, fmt.Sprintf("User %s not found", username))
+		return
+	}
+
+	if msg, ok := t.validAssignments(body.Applications); !ok {
+		s.writeServiceError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	t.users[i].Name = body.Name
+	t.users[i].Applications = body.Applications
+	s.writeAPI(w, http.StatusOK, map[string]string{"message": "User updated"})
+}
+
+func (s *Server) deleteUser(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+
+	s.mu.Lock()
+	t := s.tenant(r)
+	defer s.mu.Unlock()
+
+	i := t.userIndex(username)
+	if i < 0 {
+		s.writeServiceError(w, http.StatusNotFound, fmt.Sprintf("User %s not found", username))
+		return
+	}
+
+	t.users = slices.Delete(t.users, i, i+1)
+	s.writeAPI(w, http.StatusOK, map[string]string{"message": "User deleted"})
+}
+
+func (s *Server) listApps(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := s.page(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	t := s.tenant(r)
+	apps := make([]arubacentral.App, 0, len(t.apps))
+	for _, app := range t.apps {
+		apps = append(apps, arubacentral.App{Name: app})
+	}
+	s.mu.Unlock()
+
+	items, total := paginate(apps, limit, offset)
+	s.writeAPI(w, http.StatusOK, arubacentral.ListResponse[arubacentral.App]{Items: items, Total: total})
+}
+
+func (s *Server) listRoles(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := s.page(w, r)
+	if !ok {
+		return
+	}
+
+	appName := r.URL.Query().Get("app_name")
+
+	s.mu.Lock()
+	t := s.tenant(r)
+	if !slices.Contains(t.apps, appName) {
+		s.mu.Unlock()
+		s.writeServiceError(w, http.StatusBadRequest, fmt.Sprintf("Invalid app_name %s", appName))
+		return
+	}
+
+	roles := make([]arubacentral.Role, 0, len(t.roles[appName]))
+	for _, role := range t.roles[appName] {
+		roles = append(roles, t.withUsers(appName, role))
+	}
+	s.mu.Unlock()
+
+	items, total := paginate(roles, limit, offset)
+	s.writeAPI(w, http.StatusOK, arubacentral.ListResponse[arubacentral.Role]{Items: items, Total: total})
+}
+
+func (s *Server) getRole(w http.ResponseWriter, r *http.Request) {
+	appName, roleName := r.PathValue("app"), r.PathValue("role")
+
+	s.mu.Lock()
+	t := s.tenant(r)
+	defer s.mu.Unlock()
+
+	i := t.roleIndex(appName, roleName)
+	if i < 0 {
+		s.writeServiceError(w, http.StatusNotFound, fmt.Sprintf("Role %s not found", roleName))
+		return
+	}
+
+	s.writeAPI(w, http.StatusOK, t.withUsers(appName, t.roles[appName][i]))
+}
+
+func (s *Server) createRole(w http.ResponseWriter, r *http.Request) {
+	appName := r.PathValue("app")
+
+	var body arubacentral.RoleBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.RoleName == "" {
+		s.writeServiceError(w, http.StatusBadRequest, "Invalid role")
+		return
+	}
+
+	s.mu.Lock()
+	t := s.tenant(r)
+	defer s.mu.Unlock()
+
+	if !slices.Contains(t.apps, appName) {
+		s.writeServiceError(w, http.StatusNotFound, fmt.Sprintf("App %s not found", appName))
+		return
+	}
+
+	if t.roleIndex(appName, body.RoleName) >= 0 {
+		s.writeServiceError(w, http.StatusConflict, fmt.Sprintf("Role %s already exists", body.RoleName))
+		return
+	}
+
+	t.roles[appName] = append(t.roles[appName], arubacentral.Role{
+		RoleName:     body.RoleName,
+		Permission:   body.Permission,
+		Applications: body.Applications,
+	})
+	s.writeAPI(w, http.StatusOK, map[string]string{"message": "Role created"})
+}
+
+func (s *Server) updateRole(w http.ResponseWriter, r *http.Request) {
+	appName, roleName := r.PathValue("app"), r.PathValue("role")
+
+	var body arubacentral.RoleBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.writeServiceError(w, http.StatusBadRequest, "Invalid role")
+		return
+	}
+
+	s.mu.Lock()
+	t := s.tenant(r)
+	defer s.mu.Unlock()
+
+	i := t.roleIndex(appName, roleName)
+	if i < 0 {
+		s.writeServiceError(w, http.StatusNotFound, fmt.Sprintf("Role %s not found", roleName))
+		return
+	}
+
+	t.roles[appName][i].Permission = body.Permission
+	t.roles[appName][i].Applications = body.Applications
+	s.writeAPI(w, http.StatusOK, map[string]string{"message": "Role updated"})
+}
+
+func (s *Server) deleteRole(w http.ResponseWriter, r *http.Request) {
+	appName, roleName := r.PathValue("app"), r.PathValue("role")
+
+	s.mu.Lock()
+	t := s.tenant(r)
+	defer s.mu.Unlock()
+
+	i := t.roleIndex(appName, roleName)
+	if i < 0 {
+		s.writeServiceError(w, http.StatusNotFound, fmt.Sprintf("Role %s not found", roleName))
+		return
+	}
+
+	t.roles[appName] = slices.Delete(t.roles[appName], i, i+1)
+	s.writeAPI(w, http.StatusOK, map[string]string{"message": "Role deleted"})
+}
+
+func (s *Server) listGroups(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := s.page(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	t := s.tenant(r)
+	groups := slices.Clone(t.groups)
+	s.mu.Unlock()
+
+	items, total := paginate(groups, limit, offset)
+
+	// every group comes wrapped in a single element list
+	data := make([][]string, 0, len(items))
+	for _, group := range items {
+		data = append(data, []string{group})
+	}
+
+	s.writeAPI(w, http.StatusOK, map[string]interface{}{"data": data, "total": total})
+}
+
+func (s *Server) listSites(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := s.page(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	t := s.tenant(r)
+	sites := slices.Clone(t.sites)
+	s.mu.Unlock()
+
+	items, total := paginate(sites, limit, offset)
+	s.writeAPI(w, http.StatusOK, map[string]interface{}{"sites": items, "total": total})
+}
+
+func (s *Server) listLabels(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := s.page(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	t := s.tenant(r)
+	labels := slices.Clone(t.labels)
+	s.mu.Unlock()
+
+	items, total := paginate(labels, limit, offset)
+	s.writeAPI(w, http.StatusOK, map[string]interface{}{"labels": items, "total": total})
+}
+
+// listCustomers lists the customers of the account. Tenants have no customers of their own.
+func (s *Server) listCustomers(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := s.page(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	var customers []arubacentral.Customer
+	if s.tenant(r) == s.Tenant {
+		customers = slices.Clone(s.customers)
+	}
+	s.mu.Unlock()
+
+	items, total := paginate(customers, limit, offset)
+	s.writeAPI(w, http.StatusOK, map[string]interface{}{"customers": items, "total": total})
+}
+
+// page parses limit and offset, clamping the limit to the endpoint's maximum.
+func (s *Server) page(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	q := r.URL.Query()
+
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit < 1 {
+		s.writeServiceError(w, http.StatusBadRequest, fmt.Sprintf("Invalid limit %q", q.Get("limit")))
+		return 0, 0, false
+	}
+
+	offset := 0
+	if q.Has("offset") {
+		offset, err = strconv.Atoi(q.Get("offset"))
+		if err != nil || offset < 0 {
+			s.writeServiceError(w, http.StatusBadRequest, fmt.Sprintf("Invalid offset %q", q.Get("offset")))
+			return 0, 0, false
+		}
+	}
+
+	if maxLimit, ok := maxLimits[r.URL.Path]; ok && limit > maxLimit {
+		limit = maxLimit
+	}
+
+	return limit, offset, true
+}
+
+func paginate[T any](items []T, limit, offset int) ([]T, uint) {
+	total := uint(len(items))
+	if offset >= len(items) {
+		return []T{}, total
+	}
+
+	end := min(offset+limit, len(items))
+
+	return items[offset:end], total
+}
+
+// withUsers fills in the users holding the role, must be called with the lock held.
+func (t *Tenant) withUsers(appName string, role arubacentral.Role) arubacentral.Role {
+	role.Users = []string{}
+	for _, user := range t.users {
+		if user.HasRole(appName, role.RoleName) {
+			role.Users = append(role.Users, user.Username)
+		}
+	}
+
+	role.NoOfUsers = len(role.Users)
+
+	return role
+}
+
+// validAssignments checks that assigned roles exist, must be called with the lock held.
+func (t *Tenant) validAssignments(apps []arubacentral.UserApplication) (string, bool) {
+	for _, app := range apps {
+		for _, info := range app.Info {
+			if t.roleIndex(app.Name, info.Role) < 0 {
+				return fmt.Sprintf("Role %s doesn't exist in app %s", info.Role, app.Name), false
+			}
+		}
+	}
+
+	return "", true
+}
+
+func (t *Tenant) userIndex(username string) int {
+	return slices.IndexFunc(t.users, func(user arubacentral.User) bool {
+		return user.Username == username
+	})
+}
+
+func (t *Tenant) roleIndex(appName, roleName string) int {
+	return slices.IndexFunc(t.roles[appName], func(role arubacentral.Role) bool {
+		return role.RoleName == roleName
+	})
+}
+
+// writeAPI writes an API response, the gateway already set the rate limit headers.
+func (s *Server) writeAPI(w http.ResponseWriter, statusCode int, body interface{}) {
+	writeJSON(w, statusCode, body)
+}
+
+// writeServiceError writes the error body of the Central services behind the gateway.
+func (s *Server) writeServiceError(w http.ResponseWriter, statusCode int, description string) {
+	writeJSON(w, statusCode, map[string]string{
+		"description":  description,
+		"error_code":   fmt.Sprintf("%04d", statusCode),
+		"service_name": "Central",
+	})
+}
+
+// fail writes the error Central sends for the status code. It must be called without the lock held.
+func (s *Server) fail(w http.ResponseWriter, statusCode int) {
+	s.mu.Lock()
+	limit, remaining := s.dailyLimit, max(s.dailyLimit-s.usedToday, 0)
+	s.mu.Unlock()
+
+	switch statusCode {
+	case http.StatusUnauthorized:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="central", error="invalid_token"`)
+		writeError(w, statusCode, "invalid_token", "Invalid access token")
+	case http.StatusTooManyRequests:
+		// an exhausted daily quota reports no remaining calls for the day, otherwise the per second quota ran out
+		if remaining > 0 {
+			setRatelimitHeaders(w, limit, remaining, 0)
+		} else {
+			setRatelimitHeaders(w, limit, 0, DefaultSecondLimit-1)
+		}
+		writeJSON(w, statusCode, map[string]string{"message": "API rate limit exceeded"})
+	case http.StatusForbidden:
+		setRatelimitHeaders(w, limit, remaining, DefaultSecondLimit-1)
+		writeError(w, statusCode, "forbidden", "Insufficient permissions for this operation")
+	default:
+		setRatelimitHeaders(w, limit, remaining, DefaultSecondLimit-1)
+		s.writeServiceError(w, statusCode, http.StatusText(statusCode))
+	}
+}
+
+// setRatelimitHeaders sets the X-Ratelimit-* headers of the gateway.
+func setRatelimitHeaders(w http.ResponseWriter, limitDay, remainingDay, remainingSecond int) {
+	h := w.Header()
+	h.Set("X-Ratelimit-Limit-day", strconv.Itoa(limitDay))
+	h.Set("X-Ratelimit-Remaining-day", strconv.Itoa(remainingDay))
+	h.Set("X-Ratelimit-Limit-second", strconv.Itoa(DefaultSecondLimit))
+	h.Set("X-Ratelimit-Remaining-second", strconv.Itoa(remainingSecond))
+}
+
+// writeError writes the OAuth style error body returned by the gateway and the token endpoints.
+func writeError(w http.ResponseWriter, statusCode int, code, description string) {
+	writeJSON(w, statusCode, map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+
+	h := r.Header.Get("Authorization")
+	if len(h) <= len(prefix) || h[:len(prefix)] != prefix {
+		return ""
+	}
+
+	return h[len(prefix):]
+}
+
+func randomToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,34 @@
+package arubacentral
+
+import (
+	"context"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+)
+
+// Backend is an API the connector syncs Aruba Central identities from.
+// Client talks to the classic Aruba Central API gateway and GreenLakeClient to tenants migrated to HPE GreenLake.
+type Backend interface {
+	ListUsers(ctx context.Context, pgVars *PaginationVars) ([]User, uint, *v2.RateLimitDescription, error)
+	GetUser(ctx context.Context, username string) (*User, *v2.RateLimitDescription, error)
+	CreateUser(ctx context.Context, user *User) (*v2.RateLimitDescription, error)
+	UpdateUser(ctx context.Context, user *User) (*v2.RateLimitDescription, error)
+	DeleteUser(ctx context.Context, username string) (*v2.RateLimitDescription, error)
+
+	ListApps(ctx context.Context, pgVars *PaginationVars) ([]App, uint, *v2.RateLimitDescription, error)
+	ListRoles(ctx context.Context, appName string, pgVars *PaginationVars) ([]Role, uint, *v2.RateLimitDescription, error)
+	GetRole(ctx context.Context, appName, roleName string) (*Role, *v2.RateLimitDescription, error)
+	CreateRole(ctx context.Context, appName string, role *Role) (*v2.RateLimitDescription, error)
+	UpdateRole(ctx context.Context, appName string, role *Role) (*v2.RateLimitDescription, error)
+	DeleteRole(ctx context.Context, appName, roleName string) (*v2.RateLimitDescription, error)
+
+	ListGroups(ctx context.Context, pgVars *PaginationVars) ([]string, uint, *v2.RateLimitDescription, error)
+	ListSites(ctx context.Context, pgVars *PaginationVars) ([]Site, uint, *v2.RateLimitDescription, error)
+	ListLabels(ctx context.Context, pgVars *PaginationVars) ([]Label, uint, *v2.RateLimitDescription, error)
+	ListCustomers(ctx context.Context, pgVars *PaginationVars) ([]Customer, uint, *v2.RateLimitDescription, error)
+}
+
+var (
+	_ Backend = (*Client)(nil)
+	_ Backend = (*GreenLakeClient)(nil)
+)
@@ -0,0 +1,192 @@
+package arubacentral
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// budgetSaveInterval is how often counted calls are written to the usage file.
+// The connector process gets no shutdown hook, so calls of the last interval of a run may go uncounted.
+const budgetSaveInterval = 5 * time.Second
+
+// apiUsage is the state kept in the usage file.
+type apiUsage struct {
+	// Day is the UTC day the calls were made on, the daily quota resets at midnight UTC.
+	Day   string `json:"day"`
+	Calls int64  `json:"calls"`
+	// LimitDay is the last X-Ratelimit-Limit-day seen, so a percentage budget is known before the first response.
+	LimitDay int64 `json:"limit_day,omitempty"`
+}
+
+// APIBudget caps the API calls the connector makes per day, so the daily quota shared with other automation isn't used up.
+// Calls are counted in a state file across runs, written every budgetSaveInterval and once the budget is reached.
+// Once the budget is reached, requests fail with ResourceExhausted without being sent,
+// which stops the sync at its last checkpoint so the next run resumes it.
+type APIBudget struct {
+	path     string
+	maxCalls int64
+	percent  float64
+
+	mu      sync.Mutex
+	usage   apiUsage
+	dirty   bool
+	savedAt time.Time
+}
+
+// NewAPIBudget returns a budget of maxCalls per day, or of percent of the daily quota if maxCalls is zero.
+func NewAPIBudget(path string, maxCalls int64, percent float64) (*APIBudget, error) {
+	b := &APIBudget{
+		path:     path,
+		maxCalls: maxCalls,
+		percent:  percent,
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("baton-aruba-central: failed to read API usage: %w", err)
+	default:
+		if err := json.Unmarshal(data, &b.usage); err != nil {
+			return nil, fmt.Errorf("baton-aruba-central: failed to parse API usage: %w", err)
+		}
+	}
+
+	return b, nil
+}
+
+// Transport counts the requests sent through the returned transport against the budget.
+func (b *APIBudget) Transport(next http.RoundTripper) http.RoundTripper {
+	return &budgetTransport{budget: b, next: next}
+}
+
+// limit returns the budget for today, or -1 while a percentage budget waits for the daily quota. Must be called with the lock held.
+func (b *APIBudget) limit() int64 {
+	if b.maxCalls > 0 {
+		return b.maxCalls
+	}
+
+	if b.usage.LimitDay <= 0 {
+		return -1
+	}
+
+	return int64(float64(b.usage.LimitDay) * b.percent / 100)
+}
+
+// take counts a call, it fails once the budget for today is used up.
+func (b *APIBudget) take(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	today := time.Now().UTC().Format(time.DateOnly)
+	if b.usage.Day != today {
+		b.usage.Day = today
+		b.usage.Calls = 0
+		b.dirty = true
+	}
+
+	if limit := b.limit(); limit >= 0 && b.usage.Calls >= limit {
+		// the next run must see the budget used up, whenever the last save was
+		b.flush(ctx)
+
+		return status.Errorf(
+			codes.ResourceExhausted,
+			"baton-aruba-central: daily API budget of %d calls used up, run the sync again after midnight UTC to resume it",
+			limit,
+		)
+	}
+
+	b.usage.Calls++
+	b.dirty = true
+
+	if time.Since(b.savedAt) >= budgetSaveInterval {
+		b.flush(ctx)
+	}
+
+	return nil
+}
+
+// observe records the daily quota reported by the API.
+func (b *APIBudget) observe(ctx context.Context, resp *http.Response) {
+	limitDay, ok := headerInt(resp.Header, "X-Ratelimit-Limit-day")
+	if !ok {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if limitDay == b.usage.LimitDay {
+		return
+	}
+
+	b.usage.LimitDay = limitDay
+	b.dirty = true
+	b.flush(ctx)
+}
+
+// flush writes the usage file if anything changed since the last save. A failed write is logged rather than failing
+// the request, the calls stay counted in memory and are written with the next save. Must be called with the lock held.
+func (b *APIBudget) flush(ctx context.Context) {
+	if !b.dirty {
+		return
+	}
+
+	// a failing write is retried at the next interval, not on every call
+	b.savedAt = time.Now()
+	if err := b.save(); err != nil {
+		ctxzap.Extract(ctx).Warn("baton-aruba-central: failed to save API usage", zap.String("path", b.path), zap.Error(err))
+		return
+	}
+
+	b.dirty = false
+}
+
+// save must be called with the lock held.
+func (b *APIBudget) save() error {
+	data, err := json.Marshal(b.usage)
+	if err != nil {
+		return err
+	}
+
+	if err := WriteFileAtomic(b.path, data); err != nil {
+		return fmt.Errorf("baton-aruba-central: failed to write API usage: %w", err)
+	}
+
+	return nil
+}
+
+type budgetTransport struct {
+	budget *APIBudget
+	next   http.RoundTripper
+}
+
+func (t *budgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.budget.take(req.Context()); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	t.budget.observe(req.Context(), resp)
+
+	return resp, nil
+}
@@ -0,0 +1,92 @@
+package arubacentral_test
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral/arubacentraltest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAPIBudgetPercentageOfDailyQuota(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	s.SetDailyLimit(200)
+
+	budget, err := arubacentral.NewAPIBudget(filepath.Join(t.TempDir(), "usage.json"), 0, 2.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accessToken, _ := s.IssueToken()
+	transport := budget.Transport(&bearerTransport{accessToken: accessToken})
+	client := arubacentral.NewClient(&http.Client{Transport: transport}, s.BaseURL())
+
+	// 2.5% of 200 calls, the quota is learned from the first response
+	for i := 0; i < 5; i++ {
+		if _, _, _, err := client.ListGroups(context.Background(), arubacentral.NewPaginationVars(20, 0)); err != nil {
+			t.Fatalf("call %d failed within the budget: %v", i+1, err)
+		}
+	}
+
+	_, _, _, err = client.ListGroups(context.Background(), arubacentral.NewPaginationVars(20, 0))
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got %v once the budget was used up, want ResourceExhausted", err)
+	}
+
+	if got := s.Requests(arubacentral.GroupsEndpoint); got != 5 {
+		t.Errorf("sent %d requests, want 5", got)
+	}
+}
+
+func TestAPIBudgetSurvivesFailedSaves(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+
+	// the usage file can't be written into a missing directory
+	budget, err := arubacentral.NewAPIBudget(filepath.Join(t.TempDir(), "missing", "usage.json"), 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accessToken, _ := s.IssueToken()
+	transport := budget.Transport(&bearerTransport{accessToken: accessToken})
+	client := arubacentral.NewClient(&http.Client{Transport: transport}, s.BaseURL())
+
+	for i := 0; i < 3; i++ {
+		if _, _, _, err := client.ListGroups(context.Background(), arubacentral.NewPaginationVars(20, 0)); err != nil {
+			t.Fatalf("call %d failed as the usage couldn't be saved: %v", i+1, err)
+		}
+	}
+
+	// calls are still counted in memory
+	_, _, _, err = client.ListGroups(context.Background(), arubacentral.NewPaginationVars(20, 0))
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got %v once the budget was used up, want ResourceExhausted", err)
+	}
+}
+
+func TestAPIBudgetSavedOnceUsedUp(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	path := filepath.Join(t.TempDir(), "usage.json")
+	accessToken, _ := s.IssueToken()
+
+	for run := 0; run < 2; run++ {
+		budget, err := arubacentral.NewAPIBudget(path, 2, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		client := arubacentral.NewClient(&http.Client{Transport: budget.Transport(&bearerTransport{accessToken: accessToken})}, s.BaseURL())
+		for i := 0; i < 3; i++ {
+			_, _, _, _ = client.ListGroups(context.Background(), arubacentral.NewPaginationVars(20, 0))
+		}
+	}
+
+	// the second run starts with the budget of the first used up
+	if got := s.Requests(arubacentral.GroupsEndpoint); got != 2 {
+		t.Errorf("sent %d requests over two runs, want 2", got)
+	}
+}
@@ -2,6 +2,7 @@ package arubacentral
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -15,54 +16,60 @@ const (
 	RolesEndpoint  = "/platform/rbac/v1/roles"
 	AppsEndpoint   = "/platform/rbac/v1/apps"
 	GroupsEndpoint = "/configuration/v2/groups"
+	SitesEndpoint  = "/central/v2/sites"
+	LabelsEndpoint = "/central/v1/labels"
 
+	MSPCustomersEndpoint = "/msp_api/v1/customers"
+
+	// ArubaCentralApp is the network management application, the only application with group scoped roles.
 	ArubaCentralApp = "nms"
+
+	// AllGroupsScope is the group scope value granting access to every group.
+	AllGroupsScope = "allgroups"
+
+	// TenantIDHeader makes MSP requests on behalf of a tenant.
+	TenantIDHeader = "TenantID"
 )
 
 type Client struct {
 	httpClient *uhttp.BaseHttpClient
-	baseHost   string
+	baseURL    *url.URL
 }
 
-func NewClient(httpClient *http.Client, baseHost string) *Client {
+// NewClient returns a client for the API gateway at baseURL, see ParseBaseURL.
+func NewClient(httpClient *http.Client, baseURL *url.URL) *Client {
 	return &Client{
 		httpClient: uhttp.NewBaseHttpClient(httpClient),
-		baseHost:   baseHost,
+		baseURL:    baseURL,
 	}
 }
 
-type PaginationVars struct {
-	Limit  uint `json:"limit"`
-	Offset uint `json:"offset"`
-}
+type tenantContextKey struct{}
 
-func NewPaginationVars(limit, offset uint) *PaginationVars {
-	return &PaginationVars{Limit: limit, Offset: offset}
+// WithTenant returns a context making client requests on behalf of the given MSP tenant.
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenantID)
 }
 
-func (pgVars *PaginationVars) Apply(params *url.Values) {
-	params.Set("limit", fmt.Sprint(pgVars.Limit))
-	params.Set("offset", fmt.Sprint(pgVars.Offset))
+// TenantFromContext returns the MSP tenant requests are made on behalf of, or an empty string for the account itself.
+func TenantFromContext(ctx context.Context) string {
+	tenantID, _ := ctx.Value(tenantContextKey{}).(string)
+	return tenantID
 }
 
-type ListResponse[T any] struct {
-	Items []T  `json:"items"`
-	Total uint `json:"total"`
-}
-
-type ErrorResponse struct {
-	Error string `json:"error"`
-	Code  int    `json:"status_code"`
-}
+// newRequest creates a request carrying the tenant from the context.
+func (c *Client) newRequest(ctx context.Context, method string, u *url.URL, options ...uhttp.RequestOption) (*http.Request, error) {
+	if tenantID := TenantFromContext(ctx); tenantID != "" {
+		options = append(options, uhttp.WithHeader(TenantIDHeader, tenantID))
+	}
 
-func (e *ErrorResponse) Message() string {
-	return fmt.Sprintf("error: %s, status code: %d", e.Error, e.Code)
+	return c.httpClient.NewRequest(ctx, method, u, options...)
 }
 
 func WithRatelimitData(resource *v2.RateLimitDescription) uhttp.DoOption {
 	return func(resp *uhttp.WrapperResponse) error {
 		rl, err := extractRateLimitData(&resp.Header)
-		if err != nil {
+		if err != nil || rl == nil {
 			return err
 		}
 
@@ -75,87 +82,143 @@ func WithRatelimitData(resource *v2.RateLimitDescription) uhttp.DoOption {
 	}
 }
 
+// ListUsers lists users with their role assignments.
 func (c *Client) ListUsers(ctx context.Context, pgVars *PaginationVars) ([]User, uint, *v2.RateLimitDescription, error) {
-	u := &url.URL{
-		Scheme: "https",
-		Host:   c.baseHost,
-		Path:   UsersEndpoint,
+	return listPage[User](ctx, c, UsersEndpoint, "", url.Values{}, pgVars)
+}
+
+func (c *Client) GetUser(ctx context.Context, username string) (*User, *v2.RateLimitDescription, error) {
+	u := EndpointURL(c.baseURL, UsersEndpoint, username)
+
+	req, err := c.newRequest(ctx, http.MethodGet, u)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	req, err := c.httpClient.NewRequest(ctx, http.MethodGet, u)
+	var res User
+	var rl v2.RateLimitDescription
+	resp, err := c.httpClient.Do(
+		req,
+		WithRatelimitData(&rl),
+		WithAPIError(),
+		uhttp.WithJSONResponse(&res),
+	)
 	if err != nil {
-		return nil, 0, nil, err
+		return nil, &rl, err
 	}
 
-	params := &url.Values{}
-	pgVars.Apply(params)
-	params.Set("app_name", ArubaCentralApp)
-	req.URL.RawQuery = params.Encode()
+	defer resp.Body.Close()
+
+	return &res, &rl, nil
+}
+
+// CreateUser creates a new user with the name and role assignments of the given user.
+func (c *Client) CreateUser(ctx context.Context, user *User) (*v2.RateLimitDescription, error) {
+	u := EndpointURL(c.baseURL, UsersEndpoint)
+
+	req, err := c.newRequest(
+		ctx,
+		http.MethodPost,
+		u,
+		uhttp.WithJSONBody(user),
+		uhttp.WithAcceptJSONHeader(),
+	)
+	if err != nil {
+		return nil, err
+	}
 
-	var res ListResponse[User]
 	var rl v2.RateLimitDescription
 	resp, err := c.httpClient.Do(
 		req,
-		uhttp.WithJSONResponse(&res),
-		uhttp.WithErrorResponse(&ErrorResponse{}),
 		WithRatelimitData(&rl),
+		WithAPIError(),
 	)
 	if err != nil {
-		return nil, 0, &rl, err
+		return &rl, err
 	}
 
 	defer resp.Body.Close()
 
-	return res.Items, res.Total, &rl, nil
+	return &rl, nil
 }
 
-func (c *Client) ListRoles(ctx context.Context, pgVars *PaginationVars) ([]Role, uint, *v2.RateLimitDescription, error) {
-	u := &url.URL{
-		Scheme: "https",
-		Host:   c.baseHost,
-		Path:   RolesEndpoint,
+// UpdateUser replaces the user's name and role assignments with the ones on the given user.
+func (c *Client) UpdateUser(ctx context.Context, user *User) (*v2.RateLimitDescription, error) {
+	u := EndpointURL(c.baseURL, UsersEndpoint, user.Username)
+
+	body := UpdateUserBody{
+		Name:         user.Name,
+		Applications: user.Applications,
 	}
 
-	req, err := c.httpClient.NewRequest(ctx, http.MethodGet, u)
+	req, err := c.newRequest(
+		ctx,
+		http.MethodPatch,
+		u,
+		uhttp.WithJSONBody(body),
+		uhttp.WithAcceptJSONHeader(),
+	)
 	if err != nil {
-		return nil, 0, nil, err
+		return nil, err
 	}
 
-	params := &url.Values{}
-	pgVars.Apply(params)
-	params.Set("app_name", ArubaCentralApp)
-	req.URL.RawQuery = params.Encode()
-
-	var res ListResponse[Role]
 	var rl v2.RateLimitDescription
 	resp, err := c.httpClient.Do(
 		req,
-		uhttp.WithJSONResponse(&res),
-		uhttp.WithErrorResponse(&ErrorResponse{}),
 		WithRatelimitData(&rl),
+		WithAPIError(),
 	)
 	if err != nil {
-		return nil, 0, &rl, err
+		return &rl, err
 	}
 
 	defer resp.Body.Close()
 
-	return res.Items, res.Total, &rl, nil
+	return &rl, nil
 }
 
-func (c *Client) GetRole(ctx context.Context, roleName string) (*Role, *v2.RateLimitDescription, error) {
-	rolePath, err := url.JoinPath(AppsEndpoint, ArubaCentralApp, "roles", roleName)
+// DeleteUser deletes the user. It returns a NotFound status error when the user doesn't exist.
+func (c *Client) DeleteUser(ctx context.Context, username string) (*v2.RateLimitDescription, error) {
+	u := EndpointURL(c.baseURL, UsersEndpoint, username)
+
+	req, err := c.newRequest(ctx, http.MethodDelete, u, uhttp.WithAcceptJSONHeader())
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	u := &url.URL{
-		Scheme: "https",
-		Host:   c.baseHost,
-		Path:   rolePath,
+	var rl v2.RateLimitDescription
+	resp, err := c.httpClient.Do(
+		req,
+		WithRatelimitData(&rl),
+		WithAPIError(),
+	)
+	if errors.Is(err, ErrNotFound) {
+		return &rl, fmt.Errorf("user %s not found: %w", username, err)
+	}
+	if err != nil {
+		return &rl, err
 	}
 
-	req, err := c.httpClient.NewRequest(ctx, http.MethodGet, u)
+	defer resp.Body.Close()
+
+	return &rl, nil
+}
+
+func (c *Client) ListApps(ctx context.Context, pgVars *PaginationVars) ([]App, uint, *v2.RateLimitDescription, error) {
+	return listPage[App](ctx, c, AppsEndpoint, "", nil, pgVars)
+}
+
+func (c *Client) ListRoles(ctx context.Context, appName string, pgVars *PaginationVars) ([]Role, uint, *v2.RateLimitDescription, error) {
+	params := url.Values{}
+	params.Set("app_name", appName)
+
+	return listPage[Role](ctx, c, RolesEndpoint, "", params, pgVars)
+}
+
+func (c *Client) GetRole(ctx context.Context, appName, roleName string) (*Role, *v2.RateLimitDescription, error) {
+	u := EndpointURL(c.baseURL, AppsEndpoint, appName, "roles", roleName)
+
+	req, err := c.newRequest(ctx, http.MethodGet, u)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -164,10 +227,13 @@ func (c *Client) GetRole(ctx context.Context, roleName string) (*Role, *v2.RateL
 	var rl v2.RateLimitDescription
 	resp, err := c.httpClient.Do(
 		req,
-		uhttp.WithJSONResponse(&res),
-		uhttp.WithErrorResponse(&ErrorResponse{}),
 		WithRatelimitData(&rl),
+		WithAPIError(),
+		uhttp.WithJSONResponse(&res),
 	)
+	if errors.Is(err, ErrNotFound) {
+		return nil, &rl, fmt.Errorf("role %s not found: %w", roleName, err)
+	}
 	if err != nil {
 		return nil, &rl, err
 	}
@@ -177,43 +243,129 @@ func (c *Client) GetRole(ctx context.Context, roleName string) (*Role, *v2.RateL
 	return &res, &rl, nil
 }
 
-func (c *Client) ListGroups(ctx context.Context, pgVars *PaginationVars) ([]string, uint, *v2.RateLimitDescription, error) {
-	u := &url.URL{
-		Scheme: "https",
-		Host:   c.baseHost,
-		Path:   GroupsEndpoint,
+// CreateRole creates a custom role in the given application.
+func (c *Client) CreateRole(ctx context.Context, appName string, role *Role) (*v2.RateLimitDescription, error) {
+	u := EndpointURL(c.baseURL, AppsEndpoint, appName, "roles")
+
+	body := RoleBody{
+		RoleName:     role.RoleName,
+		Permission:   role.Permission,
+		Applications: role.Applications,
 	}
 
-	req, err := c.httpClient.NewRequest(ctx, http.MethodGet, u)
+	req, err := c.newRequest(
+		ctx,
+		http.MethodPost,
+		u,
+		uhttp.WithJSONBody(body),
+		uhttp.WithAcceptJSONHeader(),
+	)
 	if err != nil {
-		return nil, 0, nil, err
+		return nil, err
 	}
 
-	params := &url.Values{}
-	pgVars.Apply(params)
-	req.URL.RawQuery = params.Encode()
+	var rl v2.RateLimitDescription
+	resp, err := c.httpClient.Do(
+		req,
+		WithRatelimitData(&rl),
+		WithAPIError(),
+	)
+	if err != nil {
+		return &rl, err
+	}
 
-	var res struct {
-		Items [][]string `json:"data"`
-		Total uint       `json:"total"`
+	defer resp.Body.Close()
+
+	return &rl, nil
+}
+
+// UpdateRole replaces the permissions of a custom role in the given application.
+func (c *Client) UpdateRole(ctx context.Context, appName string, role *Role) (*v2.RateLimitDescription, error) {
+	u := EndpointURL(c.baseURL, AppsEndpoint, appName, "roles", role.RoleName)
+
+	body := RoleBody{
+		Permission:   role.Permission,
+		Applications: role.Applications,
 	}
+
+	req, err := c.newRequest(
+		ctx,
+		http.MethodPatch,
+		u,
+		uhttp.WithJSONBody(body),
+		uhttp.WithAcceptJSONHeader(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
 	var rl v2.RateLimitDescription
 	resp, err := c.httpClient.Do(
 		req,
-		uhttp.WithJSONResponse(&res),
-		uhttp.WithErrorResponse(&ErrorResponse{}),
 		WithRatelimitData(&rl),
+		WithAPIError(),
 	)
 	if err != nil {
-		return nil, 0, &rl, err
+		return &rl, err
 	}
 
 	defer resp.Body.Close()
 
+	return &rl, nil
+}
+
+// DeleteRole deletes a custom role from the given application.
+// It returns a NotFound status error when the role doesn't exist.
+func (c *Client) DeleteRole(ctx context.Context, appName, roleName string) (*v2.RateLimitDescription, error) {
+	u := EndpointURL(c.baseURL, AppsEndpoint, appName, "roles", roleName)
+
+	req, err := c.newRequest(ctx, http.MethodDelete, u, uhttp.WithAcceptJSONHeader())
+	if err != nil {
+		return nil, err
+	}
+
+	var rl v2.RateLimitDescription
+	resp, err := c.httpClient.Do(
+		req,
+		WithRatelimitData(&rl),
+		WithAPIError(),
+	)
+	if errors.Is(err, ErrNotFound) {
+		return &rl, fmt.Errorf("role %s not found: %w", roleName, err)
+	}
+	if err != nil {
+		return &rl, err
+	}
+
+	defer resp.Body.Close()
+
+	return &rl, nil
+}
+
+func (c *Client) ListGroups(ctx context.Context, pgVars *PaginationVars) ([]string, uint, *v2.RateLimitDescription, error) {
+	// every row of the data holds a single group name
+	rows, total, rl, err := listPage[[]string](ctx, c, GroupsEndpoint, "", nil, pgVars)
+	if err != nil {
+		return nil, 0, rl, err
+	}
+
 	var groups []string
-	for _, group := range res.Items {
-		groups = append(groups, group...)
+	for _, row := range rows {
+		groups = append(groups, row...)
 	}
 
-	return groups, res.Total, &rl, nil
+	return groups, total, rl, nil
+}
+
+func (c *Client) ListSites(ctx context.Context, pgVars *PaginationVars) ([]Site, uint, *v2.RateLimitDescription, error) {
+	return listPage[Site](ctx, c, SitesEndpoint, "sites", nil, pgVars)
+}
+
+func (c *Client) ListLabels(ctx context.Context, pgVars *PaginationVars) ([]Label, uint, *v2.RateLimitDescription, error) {
+	return listPage[Label](ctx, c, LabelsEndpoint, "labels", nil, pgVars)
+}
+
+// ListCustomers lists tenants of an MSP account.
+func (c *Client) ListCustomers(ctx context.Context, pgVars *PaginationVars) ([]Customer, uint, *v2.RateLimitDescription, error) {
+	return listPage[Customer](ctx, c, MSPCustomersEndpoint, "customers", nil, pgVars)
 }
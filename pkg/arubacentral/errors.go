@@ -0,0 +1,216 @@
+package arubacentral
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/conductorone/baton-sdk/pkg/uhttp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The kinds of API errors, match them with errors.Is.
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrValidation   = errors.New("validation failed")
+)
+
+// credentialErrorCodes are OAuth error codes for credentials that were rejected, whatever the status code.
+var credentialErrorCodes = []string{"invalid_token", "invalid_grant", "invalid_client", "unauthorized_client"}
+
+// APIError is an error response of the Aruba Central or HPE GreenLake API.
+// It carries a gRPC status, so the baton runtime retries only what is worth retrying:
+// an exhausted per second quota or an unavailable gateway, but not rejected credentials or requests.
+type APIError struct {
+	StatusCode int
+	// Code is the error code of the body, such as invalid_token or a service error code.
+	Code string
+	// Description explains the error, from whichever field the service put it in.
+	Description string
+	// Service is the Central service that failed, if it said so.
+	Service string
+	// QuotaExhausted is set on rate limited responses once the daily quota is used up, which no retry fixes before midnight UTC.
+	QuotaExhausted bool
+}
+
+// errorBody holds the fields of the error bodies Central and GreenLake send:
+// OAuth errors, service errors, gateway errors and GreenLake errors.
+type errorBody struct {
+	Error            string      `json:"error"`
+	ErrorDescription string      `json:"error_description"`
+	Description      string      `json:"description"`
+	ErrorCode        interface{} `json:"error_code"`
+	ServiceName      string      `json:"service_name"`
+	Message          string      `json:"message"`
+	Detail           interface{} `json:"detail"`
+	GreenLakeCode    string      `json:"errorCode"`
+}
+
+// NewAPIError reads the error response. The body is restored so it can still be read.
+func NewAPIError(resp *http.Response) *APIError {
+	var body []byte
+	if resp.Body != nil {
+		// the body is informational only, a missing or malformed one still yields the status code
+		body, _ = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	return newAPIError(resp.StatusCode, resp.Header, body)
+}
+
+func newAPIError(statusCode int, header http.Header, body []byte) *APIError {
+	e := &APIError{StatusCode: statusCode}
+
+	if statusCode == http.StatusTooManyRequests {
+		remainingDay, ok := headerInt(header, "X-Ratelimit-Remaining-day")
+		e.QuotaExhausted = ok && remainingDay <= 0
+	}
+
+	var b errorBody
+	if err := json.Unmarshal(body, &b); err != nil {
+		// gateways and proxies answer with plain text or HTML, only a short plain text body is worth keeping
+		if text := strings.TrimSpace(string(body)); len(text) <= 200 && !strings.HasPrefix(text, "<") {
+			e.Description = text
+		}
+
+		return e
+	}
+
+	e.Code = firstNonEmpty(b.Error, errorCodeString(b.ErrorCode), b.GreenLakeCode)
+	e.Description = firstNonEmpty(b.ErrorDescription, b.Description, b.Message, errorCodeString(b.Detail))
+	e.Service = b.ServiceName
+
+	return e
+}
+
+// WithAPIError turns error responses into an *APIError. It must come after WithRatelimitData, so error responses
+// keep their rate limits, and before options decoding the response body.
+func WithAPIError() uhttp.DoOption {
+	return func(resp *uhttp.WrapperResponse) error {
+		if resp.StatusCode < 300 {
+			return nil
+		}
+
+		return newAPIError(resp.StatusCode, resp.Header, resp.Body)
+	}
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("API request failed with status %d", e.StatusCode)
+	if e.Service != "" {
+		msg += " from " + e.Service
+	}
+
+	for _, s := range []string{e.Code, e.Description} {
+		if s != "" {
+			msg += ": " + s
+		}
+	}
+
+	return msg
+}
+
+// kind returns the error kind matched by errors.Is, or nil for other errors.
+func (e *APIError) kind() error {
+	if slices.Contains(credentialErrorCodes, e.Code) {
+		return ErrUnauthorized
+	}
+
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusForbidden:
+		return ErrForbidden
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return ErrValidation
+	default:
+		return nil
+	}
+}
+
+func (e *APIError) Is(target error) bool {
+	kind := e.kind()
+
+	return kind != nil && kind == target
+}
+
+// GRPCStatus maps the error to a gRPC status, used by status.Code and status.FromError.
+func (e *APIError) GRPCStatus() *status.Status {
+	return status.New(e.grpcCode(), e.Error())
+}
+
+func (e *APIError) grpcCode() codes.Code {
+	switch e.kind() {
+	case ErrNotFound:
+		return codes.NotFound
+	case ErrUnauthorized:
+		return codes.Unauthenticated
+	case ErrForbidden:
+		return codes.PermissionDenied
+	case ErrValidation:
+		return codes.InvalidArgument
+	case ErrRateLimited:
+		// the per second quota resets right away, the daily one only at midnight UTC
+		if e.QuotaExhausted {
+			return codes.ResourceExhausted
+		}
+		return codes.Unavailable
+	}
+
+	switch e.StatusCode {
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	}
+
+	if e.StatusCode >= 500 {
+		return codes.Internal
+	}
+
+	return codes.Unknown
+}
+
+// errorCodeString formats an error field that isn't always a string, like numeric error codes or structured details.
+func errorCodeString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return fmt.Sprint(v)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return ""
+		}
+		return string(b)
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,97 @@
+package arubacentral_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral/arubacentraltest"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAPIErrorCodes(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		wantKind   error
+		wantCode   codes.Code
+		wantDesc   string
+	}{
+		{http.StatusBadRequest, arubacentral.ErrValidation, codes.InvalidArgument, "Bad Request"},
+		{http.StatusUnauthorized, arubacentral.ErrUnauthorized, codes.Unauthenticated, "Invalid access token"},
+		{http.StatusForbidden, arubacentral.ErrForbidden, codes.PermissionDenied, "Insufficient permissions for this operation"},
+		{http.StatusNotFound, arubacentral.ErrNotFound, codes.NotFound, "Not Found"},
+		{http.StatusTooManyRequests, arubacentral.ErrRateLimited, codes.Unavailable, "API rate limit exceeded"},
+		{http.StatusInternalServerError, nil, codes.Internal, "Internal Server Error"},
+		{http.StatusServiceUnavailable, nil, codes.Unavailable, "Service Unavailable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.statusCode), func(t *testing.T) {
+			s := arubacentraltest.NewServer(t)
+			accessToken, _ := s.IssueToken()
+			client := arubacentral.NewClient(&http.Client{Transport: &bearerTransport{accessToken: accessToken}}, s.BaseURL())
+
+			s.Fail(arubacentral.GroupsEndpoint, tt.statusCode)
+
+			_, _, _, err := client.ListGroups(context.Background(), arubacentral.NewPaginationVars(20, 0))
+
+			var apiErr *arubacentral.APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("got %v, want an APIError", err)
+			}
+
+			if apiErr.Description != tt.wantDesc {
+				t.Errorf("got description %q, want %q", apiErr.Description, tt.wantDesc)
+			}
+
+			if tt.wantKind != nil && !errors.Is(err, tt.wantKind) {
+				t.Errorf("got %v, want %v", err, tt.wantKind)
+			}
+
+			if got := status.Code(err); got != tt.wantCode {
+				t.Errorf("got %s, want %s", got, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestAPIErrorExhaustedDailyQuota(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	s.SetDailyLimit(0)
+	accessToken, _ := s.IssueToken()
+	client := arubacentral.NewClient(&http.Client{Transport: &bearerTransport{accessToken: accessToken}}, s.BaseURL())
+
+	_, _, rl, err := client.ListGroups(context.Background(), arubacentral.NewPaginationVars(20, 0))
+	if !errors.Is(err, arubacentral.ErrRateLimited) {
+		t.Fatalf("got %v, want a rate limited error", err)
+	}
+
+	// the rate limits of the error response are reported with it
+	if rl.Status != v2.RateLimitDescription_STATUS_OVERLIMIT || rl.Remaining != 0 || rl.ResetAt == nil {
+		t.Errorf("got rate limit %v with the error, want the exhausted daily quota", rl)
+	}
+
+	// retrying before midnight UTC is pointless, so the sync must stop instead
+	if got := status.Code(err); got != codes.ResourceExhausted {
+		t.Errorf("got %s, want ResourceExhausted", got)
+	}
+}
+
+func TestAPIErrorWithoutRateLimitHeaders(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	client := arubacentral.NewClient(&http.Client{Transport: &bearerTransport{accessToken: "revoked"}}, s.BaseURL())
+
+	// requests rejected before the quota is counted carry no rate limits, which mustn't read as over the limit
+	_, _, rl, err := client.ListGroups(context.Background(), arubacentral.NewPaginationVars(20, 0))
+	if !errors.Is(err, arubacentral.ErrUnauthorized) {
+		t.Fatalf("got %v, want an unauthorized error", err)
+	}
+
+	if rl.Status != v2.RateLimitDescription_STATUS_UNSPECIFIED {
+		t.Errorf("got rate limit %v without rate limit headers, want none", rl)
+	}
+}
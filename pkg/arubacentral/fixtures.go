@@ -0,0 +1,326 @@
+package arubacentral
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+type FixtureMode int
+
+const (
+	// FixtureRecord passes requests on and captures the scrubbed responses.
+	FixtureRecord FixtureMode = iota + 1
+	// FixtureReplay serves captured responses without touching the network.
+	FixtureReplay
+)
+
+// scrubbedValue replaces secrets, which aren't needed to reproduce anything.
+const scrubbedValue = "REDACTED"
+
+var (
+	// secretKeys hold credentials, compared in lower case.
+	secretKeys = []string{"access_token", "refresh_token", "auth_code", "code", "client_secret", "password"}
+	// personalKeys hold personal data, compared in lower case. Their values are replaced with pseudonyms.
+	personalKeys = []string{
+		"username", "users", "email",
+		"firstname", "lastname", "first_name", "last_name",
+		"customer_name", "address", "zipcode",
+	}
+
+	emailPattern = regexp.MustCompile(`[^\s@"/]+@[^\s@"/]+\.[^\s@"/]+`)
+
+	// fixtureHeaders are the response headers worth keeping, everything else may carry session state.
+	fixtureHeaders = []string{"Content-Type", "Date", "X-Ratelimit-Limit-day", "X-Ratelimit-Remaining-day", "X-Ratelimit-Limit-second", "X-Ratelimit-Remaining-second"}
+)
+
+// Fixture is a captured API interaction.
+type Fixture struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Query  string `json:"query,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+
+	StatusCode int               `json:"status_code"`
+	Header     map[string]string `json:"header"`
+	Body       string            `json:"body"`
+}
+
+func (f *Fixture) key() string {
+	return strings.Join([]string{f.Method, f.Path, f.Query, f.Tenant}, " ")
+}
+
+// FixtureTransport records API responses to a file or replays them from it, so captures can be attached to
+// bug reports and parsing failures reproduced offline. Tokens are dropped and personal data, such as usernames and
+// names, is replaced with pseudonyms that stay consistent within a capture, so references between responses still match.
+// Request bodies and headers other than the tenant are never captured.
+// Captures hold one JSON encoded fixture per line, appended as responses come in.
+type FixtureTransport struct {
+	mode FixtureMode
+	next http.RoundTripper
+
+	mu       sync.Mutex
+	file     *os.File
+	salt     []byte
+	fixtures []Fixture
+	// served counts replays per request, repeated requests are answered in capture order.
+	served map[string]int
+}
+
+// NewFixtureTransport returns a transport recording to or replaying from the file at path.
+// The next transport is only used for recording, which replaces the file. Replaying loads the file right away.
+func NewFixtureTransport(mode FixtureMode, path string, next http.RoundTripper) (*FixtureTransport, error) {
+	t := &FixtureTransport{
+		mode:   mode,
+		next:   next,
+		served: make(map[string]int),
+	}
+
+	switch mode {
+	case FixtureRecord:
+		t.salt = make([]byte, 16)
+		if _, err := rand.Read(t.salt); err != nil {
+			return nil, err
+		}
+
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("baton-aruba-central: failed to create HTTP fixtures: %w", err)
+		}
+
+		t.file = f
+
+	case FixtureReplay:
+		fixtures, err := readFixtures(path)
+		if err != nil {
+			return nil, err
+		}
+
+		t.fixtures = fixtures
+
+	default:
+		return nil, fmt.Errorf("baton-aruba-central: unknown fixture mode %d", mode)
+	}
+
+	return t, nil
+}
+
+// readFixtures loads a capture.
+func readFixtures(path string) ([]Fixture, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("baton-aruba-central: failed to read HTTP fixtures: %w", err)
+	}
+	defer f.Close()
+
+	var fixtures []Fixture
+	d := json.NewDecoder(f)
+	for {
+		var fixture Fixture
+		err := d.Decode(&fixture)
+		if errors.Is(err, io.EOF) {
+			return fixtures, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("baton-aruba-central: failed to parse HTTP fixture %d: %w", len(fixtures)+1, err)
+		}
+
+		fixtures = append(fixtures, fixture)
+	}
+}
+
+func (t *FixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.mode == FixtureReplay {
+		return t.replay(req)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	if err := t.record(req, resp, body); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (t *FixtureTransport) record(req *http.Request, resp *http.Response, body []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f := t.request(req)
+	f.StatusCode = resp.StatusCode
+	f.Header = make(map[string]string)
+	for _, h := range fixtureHeaders {
+		if v := resp.Header.Get(h); v != "" {
+			f.Header[h] = v
+		}
+	}
+	f.Body = t.scrubBody(body)
+
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	// every fixture is written as it comes in, so the capture is complete whenever the connector stops
+	if _, err := t.file.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("baton-aruba-central: failed to write HTTP fixtures: %w", err)
+	}
+
+	return nil
+}
+
+func (t *FixtureTransport) replay(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	want := t.request(req)
+	key := want.key()
+
+	var matches []*Fixture
+	for i := range t.fixtures {
+		if t.fixtures[i].key() == key {
+			matches = append(matches, &t.fixtures[i])
+		}
+	}
+
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("baton-aruba-central: no HTTP fixture for %s", key)
+	}
+
+	// once all captured responses were served, the last one keeps being served
+	n := t.served[key]
+	t.served[key]++
+	f := matches[min(n, len(matches)-1)]
+
+	header := make(http.Header)
+	for k, v := range f.Header {
+		header.Set(k, v)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", f.StatusCode, http.StatusText(f.StatusCode)),
+		StatusCode:    f.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(f.Body)),
+		ContentLength: int64(len(f.Body)),
+		Request:       req,
+	}, nil
+}
+
+// request returns the scrubbed request part of a fixture. Replayed requests carry pseudonyms already,
+// so they are left alone.
+func (t *FixtureTransport) request(req *http.Request) Fixture {
+	query := req.URL.Query()
+	f := Fixture{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Tenant: req.Header.Get(TenantIDHeader),
+	}
+
+	if t.mode == FixtureRecord {
+		f.Path = emailPattern.ReplaceAllStringFunc(f.Path, t.pseudonym)
+		for _, values := range query {
+			for i, v := range values {
+				values[i] = emailPattern.ReplaceAllStringFunc(v, t.pseudonym)
+			}
+		}
+	}
+
+	f.Query = query.Encode()
+
+	return f
+}
+
+// scrubBody scrubs a JSON body field by field, anything else only has email addresses replaced.
+func (t *FixtureTransport) scrubBody(body []byte) string {
+	// numbers are kept as they were, decoding them as floats would turn large ids into exponents
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return emailPattern.ReplaceAllStringFunc(string(body), t.pseudonym)
+	}
+
+	b, err := json.Marshal(t.scrub("", v))
+	if err != nil {
+		return scrubbedValue
+	}
+
+	return string(b)
+}
+
+func (t *FixtureTransport) scrub(key string, v interface{}) interface{} {
+	key = strings.ToLower(key)
+
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, item := range value {
+			value[k] = t.scrub(k, item)
+		}
+		return value
+
+	case []interface{}:
+		for i, item := range value {
+			value[i] = t.scrub(key, item)
+		}
+		return value
+
+	case string:
+		switch {
+		case slices.Contains(secretKeys, key):
+			return scrubbedValue
+		case slices.Contains(personalKeys, key):
+			return t.pseudonym(value)
+		default:
+			return emailPattern.ReplaceAllStringFunc(value, t.pseudonym)
+		}
+
+	default:
+		return v
+	}
+}
+
+// pseudonym derives a stable replacement for a personal value, which still looks like an email address if it was one.
+func (t *FixtureTransport) pseudonym(value string) string {
+	if value == "" {
+		return value
+	}
+
+	h := sha256.New()
+	h.Write(t.salt)
+	h.Write([]byte(value))
+	id := hex.EncodeToString(h.Sum(nil))[:12]
+
+	if strings.Contains(value, "@") {
+		return fmt.Sprintf("user-%s@example.invalid", id)
+	}
+
+	return "redacted-" + id
+}
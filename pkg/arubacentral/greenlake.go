@@ -0,0 +1,363 @@
+package arubacentral
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/uhttp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// GreenLakeAPIHost is the global HPE GreenLake API gateway.
+	GreenLakeAPIHost = "global.api.greenlake.hpe.com"
+
+	GreenLakeUsersEndpoint        = "/identity/v1/users"
+	GreenLakeApplicationsEndpoint = "/authorization/v1/applications"
+	// GreenLakeRoleAssignmentsPath is appended to a user's path to address their role assignments.
+	GreenLakeRoleAssignmentsPath = "role-assignments"
+)
+
+// GreenLakeClient talks to tenants migrated to HPE GreenLake. Users and their role assignments come from the
+// GreenLake identity and authorization APIs. GreenLake users are mapped onto the Aruba Central user model,
+// so builders work the same against either backend.
+// Groups, sites and labels aren't supported: they are only served by the classic Central API gateway,
+// which isn't known to accept GreenLake client credentials tokens.
+type GreenLakeClient struct {
+	httpClient *uhttp.BaseHttpClient
+	baseURL    *url.URL
+}
+
+// NewGreenLakeClient returns a client for the GreenLake API at baseURL.
+func NewGreenLakeClient(httpClient *http.Client, baseURL *url.URL) *GreenLakeClient {
+	return &GreenLakeClient{
+		httpClient: uhttp.NewBaseHttpClient(httpClient),
+		baseURL:    baseURL,
+	}
+}
+
+// do sends a request to the GreenLake API and decodes the JSON response into res, unless res is nil.
+// It returns a NotFound status error on 404 responses.
+func (c *GreenLakeClient) do(
+	ctx context.Context,
+	method string,
+	u *url.URL,
+	params url.Values,
+	body interface{},
+	res interface{},
+) (*v2.RateLimitDescription, error) {
+	u.RawQuery = params.Encode()
+
+	options := []uhttp.RequestOption{uhttp.WithAcceptJSONHeader()}
+	if body != nil {
+		options = append(options, uhttp.WithJSONBody(body))
+	}
+
+	req, err := c.httpClient.NewRequest(ctx, method, u, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	var rl v2.RateLimitDescription
+	doOptions := []uhttp.DoOption{
+		WithRatelimitData(&rl),
+		WithAPIError(),
+	}
+	if res != nil {
+		doOptions = append(doOptions, uhttp.WithJSONResponse(res))
+	}
+
+	resp, err := c.httpClient.Do(req, doOptions...)
+	if err != nil {
+		return &rl, err
+	}
+
+	defer resp.Body.Close()
+
+	return &rl, nil
+}
+
+// ListUsers lists users with their role assignments.
+// Role assignments are fetched per user, as the users API doesn't include them.
+func (c *GreenLakeClient) ListUsers(ctx context.Context, pgVars *PaginationVars) ([]User, uint, *v2.RateLimitDescription, error) {
+	params := url.Values{}
+	pgVars.Apply(&params)
+
+	var res ListResponse[GreenLakeUser]
+	rl, err := c.do(ctx, http.MethodGet, EndpointURL(c.baseURL, GreenLakeUsersEndpoint), params, nil, &res)
+	if err != nil {
+		return nil, 0, rl, err
+	}
+
+	users := make([]User, 0, len(res.Items))
+	for _, glUser := range res.Items {
+		user, assignmentsRL, err := c.userWithAssignments(ctx, &glUser) // #nosec G601
+		if err != nil {
+			return nil, 0, assignmentsRL, err
+		}
+
+		rl = assignmentsRL
+		users = append(users, *user)
+	}
+
+	return users, res.Total, rl, nil
+}
+
+func (c *GreenLakeClient) GetUser(ctx context.Context, username string) (*User, *v2.RateLimitDescription, error) {
+	glUser, rl, err := c.findUser(ctx, username)
+	if err != nil {
+		return nil, rl, err
+	}
+
+	return c.userWithAssignments(ctx, glUser)
+}
+
+// CreateUser invites the user to the workspace and assigns the user's roles.
+func (c *GreenLakeClient) CreateUser(ctx context.Context, user *User) (*v2.RateLimitDescription, error) {
+	body := struct {
+		Email            string `json:"email"`
+		SendWelcomeEmail bool   `json:"sendWelcomeEmail"`
+	}{
+		Email:            user.Username,
+		SendWelcomeEmail: true,
+	}
+
+	var glUser GreenLakeUser
+	rl, err := c.do(ctx, http.MethodPost, EndpointURL(c.baseURL, GreenLakeUsersEndpoint), nil, body, &glUser)
+	if err != nil {
+		return rl, err
+	}
+
+	return c.setRoleAssignments(ctx, glUser.ID, user)
+}
+
+// UpdateUser replaces the user's role assignments with the ones on the given user.
+// Names are managed by the users themselves in GreenLake and aren't updated.
+func (c *GreenLakeClient) UpdateUser(ctx context.Context, user *User) (*v2.RateLimitDescription, error) {
+	glUser, rl, err := c.findUser(ctx, user.Username)
+	if err != nil {
+		return rl, err
+	}
+
+	return c.setRoleAssignments(ctx, glUser.ID, user)
+}
+
+// DeleteUser removes the user from the workspace. It returns a NotFound status error when the user doesn't exist.
+func (c *GreenLakeClient) DeleteUser(ctx context.Context, username string) (*v2.RateLimitDescription, error) {
+	glUser, rl, err := c.findUser(ctx, username)
+	if err != nil {
+		return rl, err
+	}
+
+	u := EndpointURL(c.baseURL, GreenLakeUsersEndpoint, glUser.ID)
+	return c.do(ctx, http.MethodDelete, u, nil, nil, nil)
+}
+
+func (c *GreenLakeClient) ListApps(ctx context.Context, pgVars *PaginationVars) ([]App, uint, *v2.RateLimitDescription, error) {
+	params := url.Values{}
+	pgVars.Apply(&params)
+
+	var res ListResponse[GreenLakeApplication]
+	rl, err := c.do(ctx, http.MethodGet, EndpointURL(c.baseURL, GreenLakeApplicationsEndpoint), params, nil, &res)
+	if err != nil {
+		return nil, 0, rl, err
+	}
+
+	apps := make([]App, 0, len(res.Items))
+	for _, app := range res.Items {
+		apps = append(apps, App{Name: app.Name})
+	}
+
+	return apps, res.Total, rl, nil
+}
+
+func (c *GreenLakeClient) ListRoles(ctx context.Context, appName string, pgVars *PaginationVars) ([]Role, uint, *v2.RateLimitDescription, error) {
+
+	params := url.Values{}
+	pgVars.Apply(&params)
+
+	var res ListResponse[GreenLakeRole]
+	u := EndpointURL(c.baseURL, GreenLakeApplicationsEndpoint, appName, "roles")
+	rl, err := c.do(ctx, http.MethodGet, u, params, nil, &res)
+	if err != nil {
+		return nil, 0, rl, err
+	}
+
+	roles := make([]Role, 0, len(res.Items))
+	for _, role := range res.Items {
+		roles = append(roles, *greenLakeRole(&role)) // #nosec G601
+	}
+
+	return roles, res.Total, rl, nil
+}
+
+// GetRole returns the role. It returns a NotFound status error when the role doesn't exist.
+func (c *GreenLakeClient) GetRole(ctx context.Context, appName, roleName string) (*Role, *v2.RateLimitDescription, error) {
+
+	var res GreenLakeRole
+	u := EndpointURL(c.baseURL, GreenLakeApplicationsEndpoint, appName, "roles", roleName)
+	rl, err := c.do(ctx, http.MethodGet, u, nil, nil, &res)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, rl, fmt.Errorf("role %s not found: %w", roleName, err)
+		}
+
+		return nil, rl, err
+	}
+
+	return greenLakeRole(&res), rl, nil
+}
+
+// CreateRole isn't supported, custom roles of migrated tenants are managed in the GreenLake console.
+func (c *GreenLakeClient) CreateRole(_ context.Context, _ string, _ *Role) (*v2.RateLimitDescription, error) {
+	return nil, status.Error(codes.Unimplemented, "custom roles are managed in the HPE GreenLake console")
+}
+
+// UpdateRole isn't supported, custom roles of migrated tenants are managed in the GreenLake console.
+func (c *GreenLakeClient) UpdateRole(_ context.Context, _ string, _ *Role) (*v2.RateLimitDescription, error) {
+	return nil, status.Error(codes.Unimplemented, "custom roles are managed in the HPE GreenLake console")
+}
+
+// DeleteRole isn't supported, custom roles of migrated tenants are managed in the GreenLake console.
+func (c *GreenLakeClient) DeleteRole(_ context.Context, _, _ string) (*v2.RateLimitDescription, error) {
+	return nil, status.Error(codes.Unimplemented, "custom roles are managed in the HPE GreenLake console")
+}
+
+// ListGroups isn't supported, groups are only served by the classic Central API gateway.
+func (c *GreenLakeClient) ListGroups(_ context.Context, _ *PaginationVars) ([]string, uint, *v2.RateLimitDescription, error) {
+	return nil, 0, nil, status.Error(codes.Unimplemented, "groups aren't supported for HPE GreenLake")
+}
+
+// ListSites isn't supported, sites are only served by the classic Central API gateway.
+func (c *GreenLakeClient) ListSites(_ context.Context, _ *PaginationVars) ([]Site, uint, *v2.RateLimitDescription, error) {
+	return nil, 0, nil, status.Error(codes.Unimplemented, "sites aren't supported for HPE GreenLake")
+}
+
+// ListLabels isn't supported, labels are only served by the classic Central API gateway.
+func (c *GreenLakeClient) ListLabels(_ context.Context, _ *PaginationVars) ([]Label, uint, *v2.RateLimitDescription, error) {
+	return nil, 0, nil, status.Error(codes.Unimplemented, "labels aren't supported for HPE GreenLake")
+}
+
+// ListCustomers isn't supported, MSP tenants aren't available through GreenLake client credentials.
+func (c *GreenLakeClient) ListCustomers(_ context.Context, _ *PaginationVars) ([]Customer, uint, *v2.RateLimitDescription, error) {
+	return nil, 0, nil, status.Error(codes.Unimplemented, "MSP mode isn't supported for HPE GreenLake")
+}
+
+// findUser looks up a GreenLake user by username. It returns a NotFound status error when the user doesn't exist.
+func (c *GreenLakeClient) findUser(ctx context.Context, username string) (*GreenLakeUser, *v2.RateLimitDescription, error) {
+	params := url.Values{}
+	params.Set("filter", fmt.Sprintf("username eq '%s'", strings.ReplaceAll(username, "'", "''")))
+
+	var res ListResponse[GreenLakeUser]
+	rl, err := c.do(ctx, http.MethodGet, EndpointURL(c.baseURL, GreenLakeUsersEndpoint), params, nil, &res)
+	if err != nil {
+		return nil, rl, err
+	}
+
+	for _, glUser := range res.Items {
+		if strings.EqualFold(glUser.Username, username) {
+			return &glUser, rl, nil
+		}
+	}
+
+	return nil, rl, status.Errorf(codes.NotFound, "user %s not found", username)
+}
+
+// userWithAssignments maps a GreenLake user and their role assignments onto an Aruba Central user.
+func (c *GreenLakeClient) userWithAssignments(ctx context.Context, glUser *GreenLakeUser) (*User, *v2.RateLimitDescription, error) {
+
+	var res ListResponse[GreenLakeRoleAssignment]
+	u := EndpointURL(c.baseURL, GreenLakeUsersEndpoint, glUser.ID, GreenLakeRoleAssignmentsPath)
+	rl, err := c.do(ctx, http.MethodGet, u, nil, nil, &res)
+	if err != nil {
+		return nil, rl, err
+	}
+
+	user := &User{
+		Username: glUser.Username,
+		Name: UserName{
+			First: glUser.FirstName,
+			Last:  glUser.LastName,
+		},
+	}
+
+	for _, assignment := range res.Items {
+		scope := UserScope{Groups: assignment.ScopeGroups}
+		if len(scope.Groups) == 0 {
+			scope.Groups = []string{AllGroupsScope}
+		}
+
+		info := UserRoleInfo{Role: assignment.RoleName, Scope: scope}
+		i := slices.IndexFunc(user.Applications, func(app UserApplication) bool {
+			return app.Name == assignment.ApplicationName
+		})
+		if i < 0 {
+			user.Applications = append(user.Applications, UserApplication{Name: assignment.ApplicationName})
+			i = len(user.Applications) - 1
+		}
+
+		user.Applications[i].Info = append(user.Applications[i].Info, info)
+	}
+
+	return user, rl, nil
+}
+
+// setRoleAssignments replaces the role assignments of the GreenLake user with the ones on the given user.
+func (c *GreenLakeClient) setRoleAssignments(ctx context.Context, userID string, user *User) (*v2.RateLimitDescription, error) {
+
+	assignments := []GreenLakeRoleAssignment{}
+	for _, app := range user.Applications {
+		for _, info := range app.Info {
+			assignment := GreenLakeRoleAssignment{
+				RoleName:        info.Role,
+				ApplicationName: app.Name,
+			}
+			if !info.Scope.HasAllGroups() {
+				assignment.ScopeGroups = info.Scope.Groups
+			}
+
+			assignments = append(assignments, assignment)
+		}
+	}
+
+	body := struct {
+		Items []GreenLakeRoleAssignment `json:"items"`
+	}{
+		Items: assignments,
+	}
+
+	u := EndpointURL(c.baseURL, GreenLakeUsersEndpoint, userID, GreenLakeRoleAssignmentsPath)
+	return c.do(ctx, http.MethodPut, u, nil, body, nil)
+}
+
+// greenLakeRole maps a GreenLake role onto an Aruba Central role. Its permissions are listed as modules of its application.
+func greenLakeRole(role *GreenLakeRole) *Role {
+	modules := make([]Module, 0, len(role.Permissions))
+	for _, permission := range role.Permissions {
+		modules = append(modules, Module{Name: permission, Permission: "allow"})
+	}
+
+	permission := "custom"
+	if role.Predefined {
+		permission = "predefined"
+	}
+
+	return &Role{
+		RoleName:   role.Name,
+		Permission: permission,
+		Applications: []Application{
+			{
+				Name:       role.ApplicationName,
+				Permission: permission,
+				Modules:    modules,
+			},
+		},
+	}
+}
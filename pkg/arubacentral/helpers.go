@@ -1,16 +1,93 @@
 package arubacentral
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// ParseBaseURL parses the base URL of an API gateway, for example https://apigw-uswest5.central.arubanetworks.com,
+// http://localhost:8080 or https://proxy.example.com/aruba. A bare hostname is accepted and defaults to https.
+func ParseBaseURL(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL %s: %w", raw, err)
+	}
+
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, fmt.Errorf("invalid base URL %s: scheme must be http or https", raw)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid base URL %s: host is required", raw)
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+	u.RawQuery = ""
+	u.Fragment = ""
+
+	return u, nil
+}
+
+// EndpointURL returns the URL of the endpoint, keeping the path prefix of the base URL.
+// The elements, such as user or role names, are appended as path segments and escaped once.
+func EndpointURL(baseURL *url.URL, endpoint string, elem ...string) *url.URL {
+	u := *baseURL
+	u.Path = baseURL.Path + endpoint
+	u.RawPath = ""
+	if len(elem) == 0 {
+		return &u
+	}
+
+	rawPath := u.EscapedPath()
+	for _, e := range elem {
+		u.Path += "/" + e
+		rawPath += "/" + url.PathEscape(e)
+	}
+	u.RawPath = rawPath
+
+	return &u
+}
+
+// WriteFileAtomic replaces the file through a rename, so a crash mid-write never leaves a truncated file behind.
+// The file is only accessible to its owner.
+func WriteFileAtomic(path string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// CreateTemp already restricts the file to its owner, which is kept through the rename
+	return os.Rename(f.Name(), path)
+}
+
+// extractRateLimitData returns nil for responses without rate limit headers, such as errors served before the gateway.
 func extractRateLimitData(header *http.Header) (*v2.RateLimitDescription, error) {
-	if header == nil {
+	if header == nil || header.Get("X-Ratelimit-Remaining-second") == "" && header.Get("X-Ratelimit-Remaining-day") == "" {
 		return nil, nil
 	}
 
@@ -1,30 +1,188 @@
 package arubacentral
 
 import (
+	"bytes"
+	"encoding/json"
 	"slices"
+	"strings"
 )
 
+type UserName struct {
+	First string `json:"firstname"`
+	Last  string `json:"lastname"`
+}
+
+type UserScope struct {
+	Groups []string `json:"groups"`
+	// Sites lists site ids, or site names on some tenants.
+	Sites ScopeIDs `json:"sites,omitempty"`
+	// Labels lists label ids, or label names on some tenants.
+	Labels ScopeIDs `json:"labels,omitempty"`
+}
+
+// ScopeIDs are ids of scope entries. Central sends them as numbers or strings depending on the tenant,
+// both are read as strings.
+type ScopeIDs []string
+
+func (ids *ScopeIDs) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	if raw == nil {
+		*ids = nil
+		return nil
+	}
+
+	rv := make(ScopeIDs, 0, len(raw))
+	for _, item := range raw {
+		var id string
+		if bytes.HasPrefix(bytes.TrimSpace(item), []byte(`"`)) {
+			if err := json.Unmarshal(item, &id); err != nil {
+				return err
+			}
+		} else {
+			var n json.Number
+			if err := json.Unmarshal(item, &n); err != nil {
+				return err
+			}
+			id = n.String()
+		}
+
+		rv = append(rv, id)
+	}
+
+	*ids = rv
+
+	return nil
+}
+
+// HasAllGroups reports whether the scope covers all groups.
+func (s *UserScope) HasAllGroups() bool {
+	return slices.Contains(s.Groups, AllGroupsScope)
+}
+
+// Clone returns a copy of the scope sharing no slices with it.
+func (s *UserScope) Clone() UserScope {
+	return UserScope{
+		Groups: slices.Clone(s.Groups),
+		Sites:  slices.Clone(s.Sites),
+		Labels: slices.Clone(s.Labels),
+	}
+}
+
+type UserRoleInfo struct {
+	Role  string    `json:"role"`
+	Scope UserScope `json:"scope"`
+}
+
+type UserApplication struct {
+	Name string         `json:"name"`
+	Info []UserRoleInfo `json:"info"`
+}
+
 type User struct {
-	Username string `json:"username"`
-	Name     struct {
-		First string `json:"firstname"`
-		Last  string `json:"lastname"`
-	} `json:"name"`
-	Applications []struct {
-		Name string `json:"name"`
-		Info []struct {
-			Role  string `json:"role"`
-			Scope struct {
-				Groups []string `json:"groups"`
-			} `json:"scope"`
-		} `json:"info"`
-	} `json:"applications"`
-}
-
-func (u *User) ContainsGroup(group string) bool {
+	Username     string            `json:"username"`
+	Name         UserName          `json:"name"`
+	Applications []UserApplication `json:"applications"`
+}
+
+// RoleScope returns the groups the user holds the role for in the given application.
+// Groups of all the user's assignments of the role are merged. It returns false when the user doesn't hold the role.
+func (u *User) RoleScope(appName, role string) ([]string, bool) {
+	var groups []string
+	found := false
+	for _, assignment := range u.RoleAssignments(appName) {
+		if assignment.Role != role {
+			continue
+		}
+
+		found = true
+		for _, group := range assignment.Scope.Groups {
+			if !slices.Contains(groups, group) {
+				groups = append(groups, group)
+			}
+		}
+	}
+
+	return groups, found
+}
+
+// RolesInGroup returns the roles the user holds for the given group across all applications.
+// Roles scoped to the group explicitly are returned separately from roles held through an all groups scope.
+func (u *User) RolesInGroup(group string) ([]string, []string) {
+	var scoped, global []string
+	for _, app := range u.Applications {
+		for _, info := range app.Info {
+			switch {
+			case info.Scope.HasAllGroups():
+				if !slices.Contains(global, info.Role) {
+					global = append(global, info.Role)
+				}
+			case slices.Contains(info.Scope.Groups, group):
+				if !slices.Contains(scoped, info.Role) {
+					scoped = append(scoped, info.Role)
+				}
+			}
+		}
+	}
+
+	return scoped, global
+}
+
+// RolesInSite returns the roles the user holds for the site identified by any of the given keys across all applications.
+func (u *User) RolesInSite(siteKeys ...string) []string {
+	return u.rolesInScope(func(s *UserScope) ScopeIDs { return s.Sites }, siteKeys)
+}
+
+// RolesInLabel returns the roles the user holds for the label identified by any of the given keys across all applications.
+func (u *User) RolesInLabel(labelKeys ...string) []string {
+	return u.rolesInScope(func(s *UserScope) ScopeIDs { return s.Labels }, labelKeys)
+}
+
+// rolesInScope returns the roles whose scope ids, as picked by ids, contain any of the keys.
+func (u *User) rolesInScope(ids func(*UserScope) ScopeIDs, keys []string) []string {
+	var roles []string
+	for _, app := range u.Applications {
+		for _, info := range app.Info {
+			for _, id := range ids(&info.Scope) {
+				if slices.Contains(keys, id) && !slices.Contains(roles, info.Role) {
+					roles = append(roles, info.Role)
+				}
+			}
+		}
+	}
+
+	return roles
+}
+
+// RoleAssignments returns the user's role assignments in the given application.
+// Assignments are returned by reference so their scope can be edited in place.
+func (u *User) RoleAssignments(appName string) []*UserRoleInfo {
+	var rv []*UserRoleInfo
+	for i, app := range u.Applications {
+		if app.Name != appName {
+			continue
+		}
+
+		for j := range app.Info {
+			rv = append(rv, &u.Applications[i].Info[j])
+		}
+	}
+
+	return rv
+}
+
+// HasRole reports whether the user holds the role in the given application.
+func (u *User) HasRole(appName, role string) bool {
 	for _, app := range u.Applications {
+		if app.Name != appName {
+			continue
+		}
+
 		for _, info := range app.Info {
-			if slices.Contains(info.Scope.Groups, group) {
+			if info.Role == role {
 				return true
 			}
 		}
@@ -33,6 +191,86 @@ func (u *User) ContainsGroup(group string) bool {
 	return false
 }
 
+// AddRole assigns the role to the user in the given application with the given scope.
+// It returns false when the user already holds the role.
+func (u *User) AddRole(appName, role string, scope UserScope) bool {
+	if u.HasRole(appName, role) {
+		return false
+	}
+
+	info := UserRoleInfo{Role: role, Scope: scope}
+	for i, app := range u.Applications {
+		if app.Name == appName {
+			u.Applications[i].Info = append(u.Applications[i].Info, info)
+			return true
+		}
+	}
+
+	u.Applications = append(u.Applications, UserApplication{Name: appName, Info: []UserRoleInfo{info}})
+
+	return true
+}
+
+// RemoveRole removes the role from the user in the given application, leaving other assignments untouched.
+// It returns false when the user doesn't hold the role.
+func (u *User) RemoveRole(appName, role string) bool {
+	removed := false
+	for i, app := range u.Applications {
+		if app.Name != appName {
+			continue
+		}
+
+		info := slices.DeleteFunc(slices.Clone(app.Info), func(info UserRoleInfo) bool {
+			return info.Role == role
+		})
+		if len(info) != len(app.Info) {
+			u.Applications[i].Info = info
+			removed = true
+		}
+	}
+
+	// drop applications left without any role assignment
+	u.Applications = slices.DeleteFunc(u.Applications, func(app UserApplication) bool {
+		return len(app.Info) == 0
+	})
+
+	return removed
+}
+
+// UpdateUserBody is the payload accepted by the RBAC user update endpoint.
+type UpdateUserBody struct {
+	Name         UserName          `json:"name"`
+	Applications []UserApplication `json:"applications"`
+}
+
+type Site struct {
+	ID          int    `json:"site_id"`
+	Name        string `json:"site_name"`
+	Address     string `json:"address"`
+	City        string `json:"city"`
+	State       string `json:"state"`
+	Country     string `json:"country"`
+	ZipCode     string `json:"zipcode"`
+	DeviceCount int    `json:"associated_device_count"`
+}
+
+type Label struct {
+	ID          int    `json:"label_id"`
+	Name        string `json:"label_name"`
+	CategoryID  int    `json:"category_id"`
+	DeviceCount int    `json:"associated_device_count"`
+}
+
+type Customer struct {
+	ID          string `json:"customer_id"`
+	Name        string `json:"customer_name"`
+	Description string `json:"description"`
+}
+
+type App struct {
+	Name string `json:"app_name"`
+}
+
 type Module struct {
 	Name       string `json:"module_name"`
 	Permission string `json:"permission"`
@@ -51,3 +289,54 @@ type Role struct {
 	Permission   string        `json:"permission"`
 	Applications []Application `json:"applications"`
 }
+
+// SystemRoles are the built-in Aruba Central roles which can't be changed.
+var SystemRoles = []string{
+	"admin",
+	"readonly",
+	"guest-operator",
+	"network-operations",
+}
+
+// IsSystemRole reports whether the role is a built-in Aruba Central role.
+func IsSystemRole(roleName string) bool {
+	return slices.ContainsFunc(SystemRoles, func(systemRole string) bool {
+		return strings.EqualFold(systemRole, roleName)
+	})
+}
+
+// RoleBody is the payload accepted by the RBAC role create and update endpoints.
+type RoleBody struct {
+	RoleName     string        `json:"rolename,omitempty"`
+	Permission   string        `json:"permission"`
+	Applications []Application `json:"applications"`
+}
+
+// GreenLakeUser is a user of an HPE GreenLake workspace.
+type GreenLakeUser struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	FirstName  string `json:"firstName"`
+	LastName   string `json:"lastName"`
+	UserStatus string `json:"userStatus"`
+}
+
+// GreenLakeRoleAssignment assigns a role of an application to a GreenLake user.
+// Scope groups limit the assignment to Central groups, an empty scope covers all groups.
+type GreenLakeRoleAssignment struct {
+	RoleName        string   `json:"roleName"`
+	ApplicationName string   `json:"applicationName"`
+	ScopeGroups     []string `json:"scopeGroups,omitempty"`
+}
+
+type GreenLakeApplication struct {
+	Name string `json:"name"`
+}
+
+type GreenLakeRole struct {
+	Name            string   `json:"name"`
+	ApplicationName string   `json:"applicationName"`
+	Description     string   `json:"description"`
+	Predefined      bool     `json:"predefined"`
+	Permissions     []string `json:"permissions"`
+}
@@ -0,0 +1,55 @@
+package arubacentral_test
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
+)
+
+func TestUserScopeAcceptsNumericIDs(t *testing.T) {
+	body := `{
+		"username": "site-admin@example.com",
+		"applications": [{
+			"name": "nms",
+			"info": [
+				{"role": "admin", "scope": {"groups": [], "sites": [1, 20000000001], "labels": [7]}},
+				{"role": "readonly", "scope": {"groups": [], "sites": ["2", "Warehouse"], "labels": ["floor-2"]}}
+			]
+		}]
+	}`
+
+	var user arubacentral.User
+	if err := json.Unmarshal([]byte(body), &user); err != nil {
+		t.Fatal(err)
+	}
+
+	scope := user.Applications[0].Info[0].Scope
+	if want := (arubacentral.ScopeIDs{"1", "20000000001"}); !slices.Equal(scope.Sites, want) {
+		t.Errorf("got sites %v, want %v", scope.Sites, want)
+	}
+
+	if want := (arubacentral.ScopeIDs{"7"}); !slices.Equal(scope.Labels, want) {
+		t.Errorf("got labels %v, want %v", scope.Labels, want)
+	}
+
+	if got := user.RolesInSite("1", "HQ"); !slices.Equal(got, []string{"admin"}) {
+		t.Errorf("got roles %v in site 1, want [admin]", got)
+	}
+
+	if got := user.RolesInSite("2", "Warehouse"); !slices.Equal(got, []string{"readonly"}) {
+		t.Errorf("got roles %v in site 2, want [readonly]", got)
+	}
+
+	if got := user.RolesInLabel("7", "floor-1"); !slices.Equal(got, []string{"admin"}) {
+		t.Errorf("got roles %v in label 7, want [admin]", got)
+	}
+}
+
+func TestUserScopeRejectsInvalidIDs(t *testing.T) {
+	var scope arubacentral.UserScope
+	if err := json.Unmarshal([]byte(`{"sites": [{"id": 1}]}`), &scope); err == nil {
+		t.Errorf("decoded a site id object as %v", scope.Sites)
+	}
+}
@@ -0,0 +1,188 @@
+package arubacentral
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/uhttp"
+)
+
+// maxPageSizes are the largest limits the list endpoints accept, Central silently returns fewer items for larger ones.
+var maxPageSizes = map[string]uint{
+	UsersEndpoint:        1000,
+	RolesEndpoint:        1000,
+	AppsEndpoint:         1000,
+	GroupsEndpoint:       20,
+	SitesEndpoint:        1000,
+	LabelsEndpoint:       1000,
+	MSPCustomersEndpoint: 50,
+}
+
+type PaginationVars struct {
+	Limit  uint `json:"limit"`
+	Offset uint `json:"offset"`
+}
+
+func NewPaginationVars(limit, offset uint) *PaginationVars {
+	return &PaginationVars{Limit: limit, Offset: offset}
+}
+
+func (pgVars *PaginationVars) Apply(params *url.Values) {
+	params.Set("limit", fmt.Sprint(pgVars.Limit))
+	params.Set("offset", fmt.Sprint(pgVars.Offset))
+}
+
+type ListResponse[T any] struct {
+	Items []T  `json:"items"`
+	Total uint `json:"total"`
+}
+
+// listPage fetches a page of a list endpoint, with the limit clamped to the endpoint's maximum.
+// The items are read from itemsKey, or from items or data, the keys most endpoints use, if it's empty.
+func listPage[T any](ctx context.Context, c *Client, endpoint, itemsKey string, params url.Values, pgVars *PaginationVars) ([]T, uint, *v2.RateLimitDescription, error) {
+	u := EndpointURL(c.baseURL, endpoint)
+
+	req, err := c.newRequest(ctx, http.MethodGet, u)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	page := *pgVars
+	if maxLimit, ok := maxPageSizes[endpoint]; ok && page.Limit > maxLimit {
+		page.Limit = maxLimit
+	}
+
+	if params == nil {
+		params = url.Values{}
+	}
+	page.Apply(&params)
+	req.URL.RawQuery = params.Encode()
+
+	var res map[string]json.RawMessage
+	var rl v2.RateLimitDescription
+	resp, err := c.httpClient.Do(
+		req,
+		WithRatelimitData(&rl),
+		WithAPIError(),
+		uhttp.WithJSONResponse(&res),
+	)
+	if err != nil {
+		return nil, 0, &rl, err
+	}
+
+	defer resp.Body.Close()
+
+	items, total, err := decodePage[T](res, itemsKey)
+	if err != nil {
+		return nil, 0, &rl, fmt.Errorf("baton-aruba-central: failed to parse %s page: %w", endpoint, err)
+	}
+
+	return items, total, &rl, nil
+}
+
+func decodePage[T any](res map[string]json.RawMessage, itemsKey string) ([]T, uint, error) {
+	var total uint
+	if raw, ok := res["total"]; ok {
+		if err := json.Unmarshal(raw, &total); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	keys := []string{"items", "data"}
+	if itemsKey != "" {
+		keys = []string{itemsKey}
+	}
+
+	for _, key := range keys {
+		raw, ok := res[key]
+		if !ok {
+			continue
+		}
+
+		var items []T
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, 0, err
+		}
+
+		return items, total, nil
+	}
+
+	return nil, total, nil
+}
+
+// ListFunc fetches a page of a list endpoint, such as the List methods of Backend.
+type ListFunc[T any] func(ctx context.Context, pgVars *PaginationVars) ([]T, uint, *v2.RateLimitDescription, error)
+
+// Pager pages through a list endpoint by limit and offset. The offset advances by the items actually returned,
+// as endpoints return fewer items than asked for when the limit exceeds their maximum.
+type Pager[T any] struct {
+	list   ListFunc[T]
+	limit  uint
+	offset uint
+	done   bool
+	rl     *v2.RateLimitDescription
+}
+
+// NewPager returns a pager fetching pages of limit items, starting at offset.
+func NewPager[T any](list ListFunc[T], limit, offset uint) *Pager[T] {
+	return &Pager[T]{
+		list:   list,
+		limit:  limit,
+		offset: offset,
+	}
+}
+
+// Next fetches the next page. Callers may stop at any page, Done reports whether there are more.
+func (p *Pager[T]) Next(ctx context.Context) ([]T, error) {
+	if p.done {
+		return nil, nil
+	}
+
+	items, total, rl, err := p.list(ctx, NewPaginationVars(p.limit, p.offset))
+	p.rl = rl
+	if err != nil {
+		return nil, err
+	}
+
+	p.offset += uint(len(items))
+	// an empty page ends the listing as well, so a total that is off can't page forever
+	p.done = len(items) == 0 || p.offset >= total
+
+	return items, nil
+}
+
+// Done reports whether the last page was fetched.
+func (p *Pager[T]) Done() bool {
+	return p.done
+}
+
+// Offset returns the offset of the next page, for resuming from a page token.
+func (p *Pager[T]) Offset() uint {
+	return p.offset
+}
+
+// RateLimit returns the rate limit reported with the last page.
+func (p *Pager[T]) RateLimit() *v2.RateLimitDescription {
+	return p.rl
+}
+
+// ListAll fetches every page of a list endpoint.
+func ListAll[T any](ctx context.Context, list ListFunc[T], limit uint) ([]T, *v2.RateLimitDescription, error) {
+	var rv []T
+
+	pager := NewPager(list, limit, 0)
+	for !pager.Done() {
+		items, err := pager.Next(ctx)
+		if err != nil {
+			return nil, pager.RateLimit(), err
+		}
+
+		rv = append(rv, items...)
+	}
+
+	return rv, pager.RateLimit(), nil
+}
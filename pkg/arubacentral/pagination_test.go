@@ -0,0 +1,92 @@
+package arubacentral_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral/arubacentraltest"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+)
+
+func TestListAllFollowsClampedPageSize(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	accessToken, _ := s.IssueToken()
+	client := arubacentral.NewClient(&http.Client{Transport: &bearerTransport{accessToken: accessToken}}, s.BaseURL())
+
+	var want []string
+	for i := 0; i < 45; i++ {
+		want = append(want, fmt.Sprintf("group-%02d", i))
+	}
+	s.AddGroups(want...)
+
+	// the groups endpoint returns at most 20 groups, whatever the limit
+	groups, _, err := arubacentral.ListAll(context.Background(), client.ListGroups, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(groups) != len(want) {
+		t.Fatalf("listed %d groups, want %d", len(groups), len(want))
+	}
+
+	for i := range want {
+		if groups[i] != want[i] {
+			t.Errorf("got group %s at %d, want %s", groups[i], i, want[i])
+		}
+	}
+
+	if got := s.Requests(arubacentral.GroupsEndpoint); got != 3 {
+		t.Errorf("sent %d requests, want 3 pages of at most 20", got)
+	}
+}
+
+func TestPagerStopsOnEmptyPage(t *testing.T) {
+	var calls int
+	// the total promises more items than the endpoint ever returns
+	list := func(_ context.Context, pgVars *arubacentral.PaginationVars) ([]int, uint, *v2.RateLimitDescription, error) {
+		calls++
+		if pgVars.Offset >= 3 {
+			return nil, 10, nil, nil
+		}
+
+		return []int{1, 2, 3}, 10, nil, nil
+	}
+
+	items, _, err := arubacentral.ListAll(context.Background(), list, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 3 {
+		t.Errorf("listed %d items, want 3", len(items))
+	}
+
+	if calls != 2 {
+		t.Errorf("fetched %d pages, want 2", calls)
+	}
+}
+
+func TestPagerResumesAtOffset(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	accessToken, _ := s.IssueToken()
+	client := arubacentral.NewClient(&http.Client{Transport: &bearerTransport{accessToken: accessToken}}, s.BaseURL())
+	s.AddGroups("a", "b", "c", "d", "e")
+
+	pager := arubacentral.NewPager(client.ListGroups, 2, 1)
+
+	groups, err := pager.Next(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(groups) != 2 || groups[0] != "b" || groups[1] != "c" {
+		t.Errorf("got %v, want [b c]", groups)
+	}
+
+	if pager.Done() || pager.Offset() != 3 {
+		t.Errorf("got done %t at offset %d, want more pages at offset 3", pager.Done(), pager.Offset())
+	}
+}
@@ -0,0 +1,240 @@
+package arubacentral
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// rateLimitRetries bounds how often a request rejected with 429 is retried.
+	rateLimitRetries = 5
+	// rateLimitBackoff is the base of the exponential jitter added on top of the reset time.
+	rateLimitBackoff = 250 * time.Millisecond
+	// maxRetryWait is the longest reset worth waiting for, an exhausted daily quota is returned to the caller instead.
+	maxRetryWait = time.Minute
+	// dailyReserve is the share of the daily quota below which requests are spread over the rest of the day.
+	dailyReserve = 0.1
+	// maxDailyPace caps the delay between requests while the daily quota runs low.
+	maxDailyPace = 30 * time.Second
+)
+
+// RateLimitTransport paces requests by the X-Ratelimit-* headers of previous responses and retries requests
+// rejected with 429 once the quota resets. Responses without the headers, such as from GreenLake, don't affect pacing.
+type RateLimitTransport struct {
+	next http.RoundTripper
+
+	mu sync.Mutex
+	// notBefore is the earliest time the next request may be sent.
+	notBefore time.Time
+
+	observedAt      time.Time
+	limitSecond     int64
+	remainingSecond int64
+	limitDay        int64
+	remainingDay    int64
+	dayReset        time.Time
+}
+
+func NewRateLimitTransport(next http.RoundTripper) *RateLimitTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &RateLimitTransport{
+		next:            next,
+		limitSecond:     -1,
+		remainingSecond: -1,
+		limitDay:        -1,
+		remainingDay:    -1,
+	}
+}
+
+func (t *RateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	// the body is buffered so the request can be retried
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		body = b
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := sleep(ctx, t.reserve()); err != nil {
+			return nil, err
+		}
+
+		r := req.Clone(ctx)
+		if body != nil {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.GetBody = func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(body)), nil
+			}
+		}
+
+		resp, err := t.next.RoundTrip(r)
+		if err != nil {
+			return nil, err
+		}
+
+		t.observe(resp)
+
+		if resp.StatusCode != http.StatusTooManyRequests || attempt >= rateLimitRetries {
+			return resp, nil
+		}
+
+		delay, ok := t.retryDelay(resp, attempt)
+		if !ok {
+			return resp, nil
+		}
+
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// reserve returns how long to wait before sending a request and holds the slot after it for the next one.
+func (t *RateLimitTransport) reserve() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	start := now
+	if t.notBefore.After(start) {
+		start = t.notBefore
+	}
+
+	t.notBefore = start.Add(t.pace(now))
+
+	return start.Sub(now)
+}
+
+// pace returns the spacing between requests for the observed budget, must be called with the lock held.
+func (t *RateLimitTransport) pace(now time.Time) time.Duration {
+	var pace time.Duration
+
+	// the per second budget is only meaningful within the second it was observed in
+	if t.limitSecond > 0 && t.remainingSecond >= 0 && now.Sub(t.observedAt) < time.Second && t.remainingSecond < t.limitSecond/2 {
+		pace = time.Second / time.Duration(t.limitSecond)
+	}
+
+	// spread what is left of the daily quota over the rest of the day, an exhausted one is left to the API to reject
+	if t.limitDay > 0 && t.remainingDay > 0 && float64(t.remainingDay) < float64(t.limitDay)*dailyReserve {
+		dailyPace := min(t.dayReset.Sub(now)/time.Duration(t.remainingDay), maxDailyPace)
+		pace = max(pace, dailyPace)
+	}
+
+	return pace
+}
+
+// observe records the rate limit headers of a response.
+func (t *RateLimitTransport) observe(resp *http.Response) {
+	limitSecond, okLimitSecond := headerInt(resp.Header, "X-Ratelimit-Limit-second")
+	remainingSecond, okRemainingSecond := headerInt(resp.Header, "X-Ratelimit-Remaining-second")
+	limitDay, okLimitDay := headerInt(resp.Header, "X-Ratelimit-Limit-day")
+	remainingDay, okRemainingDay := headerInt(resp.Header, "X-Ratelimit-Remaining-day")
+
+	if !okLimitSecond && !okRemainingSecond && !okLimitDay && !okRemainingDay {
+		return
+	}
+
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.observedAt = now
+	if okLimitSecond {
+		t.limitSecond = limitSecond
+	}
+	if okRemainingSecond {
+		t.remainingSecond = remainingSecond
+	}
+	if okLimitDay {
+		t.limitDay = limitDay
+	}
+	if okRemainingDay {
+		t.remainingDay = remainingDay
+		t.dayReset = nextDay(resp.Header, now)
+	}
+
+	// the per second quota is used up, nothing goes out before the next second
+	if okRemainingSecond && remainingSecond <= 0 && t.notBefore.Before(now.Add(time.Second)) {
+		t.notBefore = now.Add(time.Second)
+	}
+}
+
+// retryDelay returns how long to wait before retrying a 429 response, it returns false when the quota resets too late.
+func (t *RateLimitTransport) retryDelay(resp *http.Response, attempt int) (time.Duration, bool) {
+	reset := time.Second
+	if retryAfter, ok := headerInt(resp.Header, "Retry-After"); ok {
+		reset = time.Duration(retryAfter) * time.Second
+	} else if remainingDay, ok := headerInt(resp.Header, "X-Ratelimit-Remaining-day"); ok && remainingDay <= 0 {
+		reset = time.Until(nextDay(resp.Header, time.Now()))
+	}
+
+	if reset > maxRetryWait {
+		return 0, false
+	}
+
+	// jitter keeps concurrent requests rejected together from being retried together
+	backoff := rateLimitBackoff << attempt
+
+	return reset + rand.N(backoff), true
+}
+
+func headerInt(header http.Header, key string) (int64, bool) {
+	v := header.Get(key)
+	if v == "" {
+		return 0, false
+	}
+
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return i, true
+}
+
+// nextDay returns when the daily quota resets, at midnight UTC after the response's date.
+func nextDay(header http.Header, now time.Time) time.Time {
+	if date, err := http.ParseTime(header.Get("Date")); err == nil {
+		now = date
+	}
+
+	now = now.UTC()
+
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
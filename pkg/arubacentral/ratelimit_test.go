@@ -0,0 +1,85 @@
+package arubacentral_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral/arubacentraltest"
+)
+
+// bearerTransport authorizes requests with a fixed access token.
+type bearerTransport struct {
+	accessToken string
+}
+
+func (t *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.accessToken)
+
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func newRateLimitedClient(s *arubacentraltest.Server) *arubacentral.Client {
+	accessToken, _ := s.IssueToken()
+	transport := arubacentral.NewRateLimitTransport(&bearerTransport{accessToken: accessToken})
+
+	return arubacentral.NewClient(&http.Client{Transport: transport}, s.BaseURL())
+}
+
+func TestRateLimitTransportRetriesTooManyRequests(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	s.AddGroups("default")
+	client := newRateLimitedClient(s)
+
+	s.Fail(arubacentral.GroupsEndpoint, http.StatusTooManyRequests, http.StatusTooManyRequests)
+
+	start := time.Now()
+	groups, _, _, err := client.ListGroups(context.Background(), arubacentral.NewPaginationVars(20, 0))
+	if err != nil {
+		t.Fatalf("listing groups failed: %v", err)
+	}
+
+	if len(groups) != 1 {
+		t.Errorf("got %d groups, want 1", len(groups))
+	}
+
+	if got := s.Requests(arubacentral.GroupsEndpoint); got != 3 {
+		t.Errorf("sent %d requests, want 3", got)
+	}
+
+	// every retry waits for the per second quota to reset
+	if elapsed := time.Since(start); elapsed < 2*time.Second {
+		t.Errorf("retried after %s, want at least 2s", elapsed)
+	}
+}
+
+func TestRateLimitTransportReturnsExhaustedDailyQuota(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	s.SetDailyLimit(1)
+	client := newRateLimitedClient(s)
+
+	if _, _, _, err := client.ListGroups(context.Background(), arubacentral.NewPaginationVars(20, 0)); err != nil {
+		t.Fatalf("listing groups failed: %v", err)
+	}
+
+	start := time.Now()
+	_, _, rl, err := client.ListGroups(context.Background(), arubacentral.NewPaginationVars(20, 0))
+	if err == nil {
+		t.Fatal("listing groups succeeded with an exhausted daily quota")
+	}
+
+	if got := s.Requests(arubacentral.GroupsEndpoint); got != 2 {
+		t.Errorf("sent %d requests, want 2", got)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("waited %s for a quota resetting tomorrow", elapsed)
+	}
+
+	if rl.Remaining != 0 {
+		t.Errorf("got %d remaining calls, want 0", rl.Remaining)
+	}
+}
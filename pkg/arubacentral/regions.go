@@ -0,0 +1,135 @@
+package arubacentral
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Region is an Aruba Central cluster and the hostname of its API gateway.
+type Region struct {
+	Name    string
+	APIHost string
+}
+
+// Regions lists the documented Aruba Central clusters, see
+// https://developer.arubanetworks.com/aruba-central/docs/api-oauth-access-token#table-domain-urls-for-api-gateway-access
+var Regions = []Region{
+	{Name: "US-1", APIHost: "app1-apigw.central.arubanetworks.com"},
+	{Name: "US-2", APIHost: "apigw-prod2.central.arubanetworks.com"},
+	{Name: "US-East1", APIHost: "apigw-us-east-1.central.arubanetworks.com"},
+	{Name: "US-West4", APIHost: "apigw-uswest4.central.arubanetworks.com"},
+	{Name: "US-West5", APIHost: "apigw-uswest5.central.arubanetworks.com"},
+	{Name: "EU-1", APIHost: "eu-apigw.central.arubanetworks.com"},
+	{Name: "EU-Central2", APIHost: "apigw-eucentral2.central.arubanetworks.com"},
+	{Name: "EU-Central3", APIHost: "apigw-eucentral3.central.arubanetworks.com"},
+	{Name: "Canada-1", APIHost: "apigw-ca.central.arubanetworks.com"},
+	{Name: "China-1", APIHost: "apigw.central.arubanetworks.com.cn"},
+	{Name: "APAC-1", APIHost: "api-ap.central.arubanetworks.com"},
+	{Name: "APAC-East1", APIHost: "apigw-apaceast.central.arubanetworks.com"},
+	{Name: "APAC-South1", APIHost: "apigw-apacsouth.central.arubanetworks.com"},
+	{Name: "UAE-North1", APIHost: "apigw-uaenorth1.central.arubanetworks.com"},
+}
+
+// DefaultRegion is used when neither a region nor an API gateway host is configured.
+const DefaultRegion = "US-West5"
+
+// LookupRegion returns the region with the given name. Names are matched ignoring case, dashes and underscores,
+// so "us-west5", "US_WEST5" and "uswest5" all refer to US-West5.
+func LookupRegion(name string) (*Region, bool) {
+	key := normalizeRegionName(name)
+	for i := range Regions {
+		if normalizeRegionName(Regions[i].Name) == key {
+			return &Regions[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// RegionForHost returns the region served by the given API gateway host.
+func RegionForHost(host string) (*Region, bool) {
+	for i := range Regions {
+		if strings.EqualFold(Regions[i].APIHost, host) {
+			return &Regions[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// RegionNames returns the names of all regions, for help and error messages.
+func RegionNames() []string {
+	names := make([]string, 0, len(Regions))
+	for _, region := range Regions {
+		names = append(names, region.Name)
+	}
+
+	return names
+}
+
+func normalizeRegionName(name string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", "_", "", " ", "").Replace(name))
+}
+
+// ProbeRegion looks for the region accepting the access token, skipping the given API gateway host.
+// Access tokens are only valid on the cluster that issued them, so the region accepting it is the one
+// the credentials belong to. Only a read-only request listing a single user is sent to each gateway.
+func ProbeRegion(ctx context.Context, httpClient *http.Client, accessToken, skipHost string) (*Region, bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	found := make(chan *Region, len(Regions))
+	var wg sync.WaitGroup
+	for i := range Regions {
+		region := &Regions[i]
+		if strings.EqualFold(region.APIHost, skipHost) {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if acceptsToken(ctx, httpClient, region.APIHost, accessToken) {
+				found <- region
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(found)
+	}()
+
+	region, ok := <-found
+	return region, ok
+}
+
+func acceptsToken(ctx context.Context, httpClient *http.Client, host, accessToken string) bool {
+	u := &url.URL{
+		Scheme:   "https",
+		Host:     host,
+		Path:     UsersEndpoint,
+		RawQuery: url.Values{"limit": {"1"}, "offset": {"0"}}.Encode(),
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false
+	}
+
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return false
+	}
+
+	defer resp.Body.Close()
+
+	return resp.StatusCode == http.StatusOK
+}
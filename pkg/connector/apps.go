@@ -0,0 +1,109 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+)
+
+type appBuilder struct {
+	client       arubacentral.Backend
+	resourceType *v2.ResourceType
+	tenancy      tenancy
+
+	// apps limits which applications are synced, all applications are synced if empty.
+	apps []string
+}
+
+func appResource(scope tenantScope, app *arubacentral.App) (*v2.Resource, error) {
+	profile := map[string]interface{}{
+		"app_name": app.Name,
+	}
+
+	opts := append(scope.resourceOptions(), rs.WithAnnotation(&v2.ChildResourceType{ResourceTypeId: roleResourceType.Id}))
+	resource, err := rs.NewAppResource(
+		app.Name,
+		appResourceType,
+		scope.resourceID(app.Name),
+		[]rs.AppTraitOption{
+			rs.WithAppProfile(profile),
+		},
+		opts...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return resource, nil
+}
+
+func (a *appBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
+	return appResourceType
+}
+
+func (a *appBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	scope, ok := a.tenancy.listScope(parentResourceID)
+	if !ok {
+		return nil, "", nil, nil
+	}
+
+	ctx = scope.context(ctx)
+	bag, offset, err := parsePageToken(pToken.Token, &v2.ResourceId{ResourceType: a.resourceType.Id})
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("failed to parse page token: %w", err)
+	}
+
+	pager := arubacentral.NewPager(a.client.ListApps, ResourcesPageSize, offset)
+	apps, err := pager.Next(ctx)
+	rl := pager.RateLimit()
+	if err != nil {
+		return nil, "", annotations.New(rl), fmt.Errorf("failed to list applications: %w", err)
+	}
+
+	var rv []*v2.Resource
+	for _, app := range apps {
+		if len(a.apps) > 0 && !slices.Contains(a.apps, app.Name) {
+			continue
+		}
+
+		resource, err := appResource(scope, &app) // #nosec G601
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("failed to create application resource: %w", err)
+		}
+
+		rv = append(rv, resource)
+	}
+
+	nextPage := nextPageToken(pager)
+	next, err := bag.NextToken(nextPage)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("failed to prepare next page token: %w", err)
+	}
+
+	return rv, next, annotations.New(rl), nil
+}
+
+// Entitlements always returns an empty slice for applications, access is granted through their roles.
+func (a *appBuilder) Entitlements(_ context.Context, _ *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+// Grants always returns an empty slice for applications since they don't have any entitlements.
+func (a *appBuilder) Grants(_ context.Context, _ *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+func newAppBuilder(client arubacentral.Backend, tenancy tenancy, apps []string) *appBuilder {
+	return &appBuilder{
+		client:       client,
+		resourceType: appResourceType,
+		tenancy:      tenancy,
+		apps:         apps,
+	}
+}
@@ -3,79 +3,264 @@ package connector
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
 	"github.com/conductorone/baton-sdk/pkg/uhttp"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
 	"golang.org/x/net/publicsuffix"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
 	LoginEndpoint    = "/oauth2/authorize/central/api/login"
 	AuthCodeEndpoint = "/oauth2/authorize/central/api"
 	TokenEndpoint    = "/oauth2/token" // #nosec G101 (hardcoded credentials are not used here)
+
+	// tokenRefreshTimeout bounds a refresh, which outlives the request that started it.
+	tokenRefreshTimeout = time.Minute
 )
 
 type Token struct {
-	AccessToken  string
-	RefreshToken string
-	ExpiresIn    time.Time
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresIn    time.Time `json:"expiry"`
+	// Origin identifies the configured refresh token this token was rotated from, see tokenOrigin.
+	Origin string `json:"origin,omitempty"`
 }
 
+// tokenOrigin hashes a configured refresh token, so a stored token can tell whether the refresh token
+// configured now is the one it was rotated from, without storing that token again.
+func tokenOrigin(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// AuthMiddleware authorizes requests with an OAuth access token and refreshes it when needed.
+// The lock only guards the token state, so requests go out in parallel,
+// and concurrent refreshes collapse into a single in-flight token request.
 type AuthMiddleware struct {
 	Transport http.RoundTripper
 	Token     *Token
 	mu        sync.Mutex
+	refreshes singleflight.Group
 
-	baseHost     string
+	baseURL      *url.URL
 	clientID     string
 	clientSecret string
+
+	// tokenStore receives every refreshed token, since refreshing invalidates the previous refresh token.
+	tokenStore TokenStore
+	// origin is stamped on every token saved to the token store.
+	origin string
+	// codeFlow re-authenticates when the refresh token is rejected, typically because it expired.
+	codeFlow *CodeFlowConfig
 }
 
+// RoundTrip authorizes the request with the current access token.
+// A supplied access token is used as is until it's known to be expired, or until the API rejects it with
+// invalid_token, in which case the token is refreshed once and the request is replayed.
 func (m *AuthMiddleware) RoundTrip(req *http.Request) (*http.Response, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	token := m.currentToken()
 
-	// check if token is expired
-	if m.Token == nil || m.Token.ExpiresIn.IsZero() || time.Now().After(m.Token.ExpiresIn) {
-		if err := m.refreshToken(req.Context()); err != nil {
+	// check if token is missing or expired
+	if token == nil || token.AccessToken == "" || (!token.ExpiresIn.IsZero() && time.Now().After(token.ExpiresIn)) {
+		var err error
+		token, err = m.refreshToken(req.Context(), token)
+		if err != nil {
 			return nil, fmt.Errorf("failed to refresh token: %w", err)
 		}
 	}
 
-	// set auth header with new token
-	req.Header.Set("Authorization", "Bearer "+m.Token.AccessToken)
+	// buffer the body so the request can be replayed
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+	}
 
-	return m.Transport.RoundTrip(req)
-}
+	resp, err := m.send(req, body, token)
+	if err != nil {
+		return nil, err
+	}
 
-func (m *AuthMiddleware) refreshToken(ctx context.Context) error {
-	accessToken, refreshToken, expiresIn, err := refreshToken(
-		ctx,
-		&http.Client{
-			Transport: m.Transport,
-		},
-		m.baseHost,
-		m.clientID,
-		m.clientSecret,
-		m.Token.RefreshToken,
-	)
+	if !isInvalidToken(resp) {
+		return resp, nil
+	}
+
+	// the token was revoked or expired before its local expiry
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	token, err = m.refreshToken(req.Context(), token)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to refresh token: %w", err)
 	}
 
-	m.Token = &Token{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresIn:    time.Now().Add(time.Duration(expiresIn) * time.Second),
+	return m.send(req, body, token)
+}
+
+// send sends a copy of the request with the given body, authorized with the given token.
+func (m *AuthMiddleware) send(req *http.Request, body []byte, token *Token) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	if body != nil {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+		r.ContentLength = int64(len(body))
 	}
 
-	return nil
+	// set auth header with current token
+	r.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+	return m.Transport.RoundTrip(r)
+}
+
+// isInvalidToken reports whether the API rejected the access token, as opposed to denying the request.
+// The response body is restored so it can still be read by the caller.
+func isInvalidToken(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+
+	if strings.Contains(resp.Header.Get("WWW-Authenticate"), "invalid_token") {
+		return true
+	}
+
+	return arubacentral.NewAPIError(resp).Code == "invalid_token"
+}
+
+func (m *AuthMiddleware) currentToken() *Token {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.Token
+}
+
+// refreshToken replaces the stale token and returns the new one.
+// Requests that failed with the same stale token share a single refresh,
+// and requests that see a stale token after it was already replaced simply pick up the new one.
+// The refresh isn't cancelled with the request that started it: Central may already have rotated the refresh token,
+// and dropping the response would leave the connector with a dead one.
+func (m *AuthMiddleware) refreshToken(ctx context.Context, stale *Token) (*Token, error) {
+	refreshed := m.refreshes.DoChan("refresh", func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), tokenRefreshTimeout)
+		defer cancel()
+
+		m.mu.Lock()
+		current := m.Token
+		codeFlow := m.codeFlow
+		m.mu.Unlock()
+
+		if current != stale {
+			return current, nil
+		}
+
+		var currentRefreshToken string
+		if current != nil {
+			currentRefreshToken = current.RefreshToken
+		}
+
+		accessToken, refreshToken, expiresIn, err := refreshToken(
+			ctx,
+			&http.Client{
+				Transport: m.Transport,
+			},
+			m.baseURL,
+			m.clientID,
+			m.clientSecret,
+			currentRefreshToken,
+		)
+		if err != nil {
+			if codeFlow == nil || !isGrantRejected(err) {
+				return nil, err
+			}
+
+			return m.reauthenticate(ctx, codeFlow, err)
+		}
+
+		token := &Token{
+			AccessToken:  accessToken,
+			RefreshToken: refreshToken,
+			ExpiresIn:    time.Now().Add(time.Duration(expiresIn) * time.Second),
+		}
+		m.setToken(ctx, token)
+
+		return token, nil
+	})
+
+	select {
+	case res := <-refreshed:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+
+		return res.Val.(*Token), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// reauthenticate replaces a rejected refresh token with a token from the code flow.
+// The code flow is attempted only once: if it fails as well, the credentials are most likely wrong,
+// and logging in again on every request would only get the account locked.
+func (m *AuthMiddleware) reauthenticate(ctx context.Context, codeFlow *CodeFlowConfig, refreshErr error) (*Token, error) {
+	l := ctxzap.Extract(ctx)
+
+	l.Warn("baton-aruba-central: refresh token was rejected, re-authenticating with code flow", zap.Error(refreshErr))
+
+	token, err := codeFlow.login(ctx, m.Transport)
+	if err != nil {
+		l.Error("baton-aruba-central: code flow failed, giving up on re-authentication", zap.Error(err))
+
+		m.mu.Lock()
+		m.codeFlow = nil
+		m.mu.Unlock()
+
+		return nil, fmt.Errorf("refresh token was rejected: %w, and code flow failed: %w", refreshErr, err)
+	}
+
+	l.Info("baton-aruba-central: re-authenticated with code flow")
+
+	m.setToken(ctx, token)
+
+	return token, nil
+}
+
+// setToken switches to the new token and persists it.
+func (m *AuthMiddleware) setToken(ctx context.Context, token *Token) {
+	token.Origin = m.origin
+
+	m.mu.Lock()
+	m.Token = token
+	m.mu.Unlock()
+
+	if m.tokenStore != nil {
+		// the refreshed token is already in use, so a failed save must not fail the request
+		if err := m.tokenStore.Save(ctx, token); err != nil {
+			ctxzap.Extract(ctx).Error("baton-aruba-central: failed to persist refreshed token", zap.Error(err))
+		}
+	}
 }
 
 type OAuthConfig interface {
@@ -89,7 +274,8 @@ func (cfg *NoConfig) GetClient(ctx context.Context) (*http.Client, error) {
 }
 
 type BaseConfig struct {
-	BaseHost     string
+	// BaseURL is the API gateway, see arubacentral.ParseBaseURL.
+	BaseURL      *url.URL
 	ClientID     string
 	ClientSecret string
 }
@@ -98,6 +284,12 @@ type RefreshTokenFlowConfig struct {
 	BaseConfig
 	AccessToken  string
 	RefreshToken string
+
+	// TokenStore persists rotated tokens. A stored token takes precedence over the configured ones,
+	// unless the configured refresh token was changed since the stored token was rotated from it.
+	TokenStore TokenStore
+	// CodeFlow, if set, is used to re-authenticate once the refresh token is rejected.
+	CodeFlow *CodeFlowConfig
 }
 
 func (cfg *RefreshTokenFlowConfig) GetClient(ctx context.Context) (*http.Client, error) {
@@ -106,21 +298,58 @@ func (cfg *RefreshTokenFlowConfig) GetClient(ctx context.Context) (*http.Client,
 		return nil, err
 	}
 
+	origin := tokenOrigin(cfg.RefreshToken)
+	token := &Token{
+		AccessToken:  cfg.AccessToken,
+		RefreshToken: cfg.RefreshToken,
+		ExpiresIn:    time.Time{},
+		Origin:       origin,
+	}
+
+	if cfg.TokenStore != nil {
+		stored, err := cfg.TokenStore.Load(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		token = preferredToken(ctx, token, stored)
+	}
+
 	return &http.Client{
 		Transport: &AuthMiddleware{
-			Transport: httpClient.Transport,
-			Token: &Token{
-				AccessToken:  cfg.AccessToken,
-				RefreshToken: cfg.RefreshToken,
-				ExpiresIn:    time.Time{},
-			},
-			baseHost:     cfg.BaseHost,
+			Transport:    httpClient.Transport,
+			Token:        token,
+			baseURL:      cfg.BaseURL,
 			clientID:     cfg.ClientID,
 			clientSecret: cfg.ClientSecret,
+			tokenStore:   cfg.TokenStore,
+			origin:       origin,
+			codeFlow:     cfg.CodeFlow,
 		},
 	}, nil
 }
 
+// preferredToken picks between the configured and the stored token. The stored one was rotated from a configured
+// refresh token and is preferred, unless a different refresh token has been configured since, which is used instead.
+func preferredToken(ctx context.Context, configured, stored *Token) *Token {
+	l := ctxzap.Extract(ctx)
+
+	switch {
+	case stored == nil:
+		return configured
+	case configured.RefreshToken == "" || stored.Origin == configured.Origin:
+		l.Debug("baton-aruba-central: using token from token store")
+		return stored
+	case stored.Origin == "":
+		// tokens stored by earlier versions don't know their origin, so they can't be told apart from a stale one
+		l.Info("baton-aruba-central: using token from token store instead of the configured refresh token, delete the token store to use the configured one")
+		return stored
+	default:
+		l.Info("baton-aruba-central: the configured refresh token changed since the stored token was saved, using the configured one")
+		return configured
+	}
+}
+
 type CodeFlowConfig struct {
 	BaseConfig
 	Username   string
@@ -129,35 +358,42 @@ type CodeFlowConfig struct {
 }
 
 func (cfg *CodeFlowConfig) GetClient(ctx context.Context) (*http.Client, error) {
-	loginURL := &url.URL{
-		Scheme: "https",
-		Host:   cfg.BaseHost,
-		Path:   LoginEndpoint,
-	}
-	authCodeURL := &url.URL{
-		Scheme: "https",
-		Host:   cfg.BaseHost,
-		Path:   AuthCodeEndpoint,
-	}
-	tokenURL := &url.URL{
-		Scheme: "https",
-		Host:   cfg.BaseHost,
-		Path:   TokenEndpoint,
+	httpClient, err := uhttp.NewClient(ctx, uhttp.WithLogger(true, nil))
+	if err != nil {
+		return nil, err
 	}
 
-	// prepare a http client with logger and cookie jar to enable code flow (for parsing csrf token from cookies)
-	httpClient, err := uhttp.NewClient(ctx, uhttp.WithLogger(true, nil))
+	token, err := cfg.login(ctx, httpClient.Transport)
 	if err != nil {
 		return nil, err
 	}
 
+	return &http.Client{
+		Transport: &AuthMiddleware{
+			Transport:    httpClient.Transport,
+			Token:        token,
+			baseURL:      cfg.BaseURL,
+			clientID:     cfg.ClientID,
+			clientSecret: cfg.ClientSecret,
+			codeFlow:     cfg,
+		},
+	}, nil
+}
+
+// login obtains a new token through the login, CSRF, auth code and token exchange sequence.
+func (cfg *CodeFlowConfig) login(ctx context.Context, transport http.RoundTripper) (*Token, error) {
+	loginURL := arubacentral.EndpointURL(cfg.BaseURL, LoginEndpoint)
+	authCodeURL := arubacentral.EndpointURL(cfg.BaseURL, AuthCodeEndpoint)
+	tokenURL := arubacentral.EndpointURL(cfg.BaseURL, TokenEndpoint)
+
+	// prepare a http client with cookie jar to enable code flow (for parsing csrf token from cookies)
 	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
 	if err != nil {
 		return nil, err
 	}
 
-	httpClient = &http.Client{
-		Transport: httpClient.Transport,
+	httpClient := &http.Client{
+		Transport: transport,
 		Jar:       jar,
 	}
 
@@ -187,17 +423,52 @@ func (cfg *CodeFlowConfig) GetClient(ctx context.Context) (*http.Client, error)
 		return nil, err
 	}
 
+	return &Token{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    time.Now().Add(time.Duration(expiresIn) * time.Second),
+	}, nil
+}
+
+// GreenLakeTokenURL is the HPE GreenLake SSO token endpoint.
+const GreenLakeTokenURL = "https://sso.common.cloud.hpe.com/as/token.oauth2" // #nosec G101 (hardcoded credentials are not used here)
+
+// GreenLakeConfig authenticates against HPE GreenLake with an OAuth2 client credentials grant,
+// for tenants migrated from Aruba Central to GreenLake. Tokens are fetched again when they expire.
+type GreenLakeConfig struct {
+	ClientID     string
+	ClientSecret string
+	// TokenURL defaults to GreenLakeTokenURL.
+	TokenURL string
+	// APIURL is the GreenLake API gateway, it defaults to arubacentral.GreenLakeAPIHost over https.
+	APIURL *url.URL
+}
+
+func (cfg *GreenLakeConfig) GetClient(ctx context.Context) (*http.Client, error) {
+	httpClient, err := uhttp.NewClient(ctx, uhttp.WithLogger(true, nil))
+	if err != nil {
+		return nil, err
+	}
+
+	tokenURL := cfg.TokenURL
+	if tokenURL == "" {
+		tokenURL = GreenLakeTokenURL
+	}
+
+	ccConfig := &clientcredentials.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		TokenURL:     tokenURL,
+		AuthStyle:    oauth2.AuthStyleInParams,
+	}
+
+	// the token source keeps using the logging client for token requests
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+
 	return &http.Client{
-		Transport: &AuthMiddleware{
-			Transport: httpClient.Transport,
-			Token: &Token{
-				AccessToken:  accessToken,
-				RefreshToken: refreshToken,
-				ExpiresIn:    time.Now().Add(time.Duration(expiresIn) * time.Second),
-			},
-			baseHost:     cfg.BaseHost,
-			clientID:     cfg.ClientID,
-			clientSecret: cfg.ClientSecret,
+		Transport: &oauth2.Transport{
+			Source: ccConfig.TokenSource(ctx),
+			Base:   httpClient.Transport,
 		},
 	}, nil
 }
@@ -238,6 +509,10 @@ func loginAndGetCSRF(ctx context.Context, httpClient *http.Client, loginURL, cli
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return arubacentral.NewAPIError(resp)
+	}
+
 	return nil
 }
 
@@ -279,7 +554,7 @@ func getAuthCode(ctx context.Context, httpClient *http.Client, authCodeURL, clie
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return "", arubacentral.NewAPIError(resp)
 	}
 
 	var respBody struct {
@@ -329,7 +604,7 @@ func exchangeCodeForToken(ctx context.Context, httpClient *http.Client, tokenURL
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", "", 0, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return "", "", 0, arubacentral.NewAPIError(resp)
 	}
 
 	var respBody struct {
@@ -345,12 +620,8 @@ func exchangeCodeForToken(ctx context.Context, httpClient *http.Client, tokenURL
 	return respBody.AccessToken, respBody.RefreshToken, respBody.ExpiresIn, nil
 }
 
-func refreshToken(ctx context.Context, httpClient *http.Client, baseHost string, clientID, clientSecret, refreshToken string) (string, string, int, error) {
-	tokenURL := &url.URL{
-		Scheme: "https",
-		Host:   baseHost,
-		Path:   TokenEndpoint,
-	}
+func refreshToken(ctx context.Context, httpClient *http.Client, baseURL *url.URL, clientID, clientSecret, refreshToken string) (string, string, int, error) {
+	tokenURL := arubacentral.EndpointURL(baseURL, TokenEndpoint)
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL.String(), nil)
 	if err != nil {
@@ -378,7 +649,7 @@ func refreshToken(ctx context.Context, httpClient *http.Client, baseHost string,
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", "", 0, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return "", "", 0, arubacentral.NewAPIError(resp)
 	}
 
 	var respBody struct {
@@ -393,3 +664,14 @@ func refreshToken(ctx context.Context, httpClient *http.Client, baseHost string,
 
 	return respBody.AccessToken, respBody.RefreshToken, respBody.ExpiresIn, nil
 }
+
+// isGrantRejected reports whether the token endpoint rejected the grant itself,
+// for example an expired or already used refresh token, rather than failing to process the request.
+func isGrantRejected(err error) bool {
+	var apiErr *arubacentral.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	return apiErr.StatusCode == http.StatusBadRequest || apiErr.StatusCode == http.StatusUnauthorized
+}
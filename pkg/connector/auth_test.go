@@ -0,0 +1,272 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
+)
+
+// tokenServer stands in for the Aruba Central token endpoint and API.
+// The API accepts only the most recently issued access token.
+type tokenServer struct {
+	*httptest.Server
+
+	refreshes atomic.Int32
+	// refreshDelay widens the window in which concurrent refreshes could overlap.
+	refreshDelay time.Duration
+	// apiHandler, if set, runs for authorized API requests.
+	apiHandler func(w http.ResponseWriter, r *http.Request)
+
+	mu    sync.Mutex
+	valid string
+}
+
+func newTokenServer(t *testing.T, validToken string) *tokenServer {
+	t.Helper()
+
+	ts := &tokenServer{valid: validToken}
+	ts.Server = httptest.NewTLSServer(http.HandlerFunc(ts.serveHTTP))
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func (ts *tokenServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == TokenEndpoint {
+		n := ts.refreshes.Add(1)
+		time.Sleep(ts.refreshDelay)
+
+		accessToken := fmt.Sprintf("access-%d", n)
+		ts.mu.Lock()
+		ts.valid = accessToken
+		ts.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  accessToken,
+			"refresh_token": fmt.Sprintf("refresh-%d", n),
+			"expires_in":    7200,
+		})
+		return
+	}
+
+	ts.mu.Lock()
+	valid := "Bearer " + ts.valid
+	ts.mu.Unlock()
+
+	if r.Header.Get("Authorization") != valid {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"error":             "invalid_token",
+			"error_description": "Invalid access token",
+		})
+		return
+	}
+
+	if ts.apiHandler != nil {
+		ts.apiHandler(w, r)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (ts *tokenServer) middleware(t *testing.T, token *Token) *AuthMiddleware {
+	t.Helper()
+
+	baseURL, err := arubacentral.ParseBaseURL(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &AuthMiddleware{
+		Transport:    ts.Client().Transport,
+		Token:        token,
+		baseURL:      baseURL,
+		clientID:     "client-id",
+		clientSecret: "client-secret",
+	}
+}
+
+// parallelGet sends n concurrent requests through the middleware and fails the test on any non-200 response.
+func parallelGet(t *testing.T, ts *tokenServer, m *AuthMiddleware, n int, body string) {
+	t.Helper()
+
+	client := &http.Client{Transport: m}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			resp, err := client.Post(ts.URL+"/platform/rbac/v1/users", "application/json", strings.NewReader(body))
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				errs <- fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestAuthMiddlewareSendsRequestsInParallel(t *testing.T) {
+	const n = 16
+
+	ts := newTokenServer(t, "supplied")
+
+	// every request blocks until all of them are in flight, which only happens if they aren't serialized
+	var inFlight sync.WaitGroup
+	inFlight.Add(n)
+	allInFlight := make(chan struct{})
+	go func() {
+		inFlight.Wait()
+		close(allInFlight)
+	}()
+
+	ts.apiHandler = func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Done()
+		select {
+		case <-allInFlight:
+			w.WriteHeader(http.StatusOK)
+		case <-time.After(5 * time.Second):
+			w.WriteHeader(http.StatusRequestTimeout)
+		}
+	}
+
+	parallelGet(t, ts, ts.middleware(t, &Token{AccessToken: "supplied", RefreshToken: "refresh"}), n, "")
+
+	if got := ts.refreshes.Load(); got != 0 {
+		t.Errorf("supplied access token was refreshed %d times, want 0", got)
+	}
+}
+
+func TestAuthMiddlewareCollapsesConcurrentRefreshes(t *testing.T) {
+	tests := []struct {
+		name  string
+		token *Token
+	}{
+		{
+			name: "expired locally",
+			token: &Token{
+				AccessToken:  "expired",
+				RefreshToken: "refresh",
+				ExpiresIn:    time.Now().Add(-time.Minute),
+			},
+		},
+		{
+			name: "revoked server side",
+			token: &Token{
+				AccessToken:  "revoked",
+				RefreshToken: "refresh",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTokenServer(t, "")
+			ts.refreshDelay = 50 * time.Millisecond
+
+			parallelGet(t, ts, ts.middleware(t, tt.token), 64, `{"username":"jane@example.com"}`)
+
+			if got := ts.refreshes.Load(); got != 1 {
+				t.Errorf("token was refreshed %d times, want 1", got)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareRefreshOutlivesCancelledRequest(t *testing.T) {
+	ts := newTokenServer(t, "")
+	ts.refreshDelay = 200 * time.Millisecond
+	m := ts.middleware(t, &Token{AccessToken: "expired", RefreshToken: "refresh", ExpiresIn: time.Now().Add(-time.Minute)})
+	client := &http.Client{Transport: m}
+
+	// the first request starts the refresh and is cancelled while it's in flight
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error, 1)
+	go func() {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/platform/rbac/v1/users", nil)
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		cancelled <- err
+	}()
+
+	for ts.refreshes.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	waiting := make(chan error, 1)
+	go func() {
+		resp, err := client.Get(ts.URL + "/platform/rbac/v1/users")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+			}
+		}
+		waiting <- err
+	}()
+
+	cancel()
+	if err := <-cancelled; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled request returned %v, want context.Canceled", err)
+	}
+
+	// the refresh completes for the request waiting on it and its token is kept
+	if err := <-waiting; err != nil {
+		t.Errorf("request waiting on the refresh failed: %v", err)
+	}
+
+	if got := m.currentToken(); got.AccessToken != "access-1" || got.RefreshToken != "refresh-1" {
+		t.Errorf("got token %+v after the refresh, want the refreshed one", got)
+	}
+
+	if got := ts.refreshes.Load(); got != 1 {
+		t.Errorf("token was refreshed %d times, want 1", got)
+	}
+}
+
+func TestAuthMiddlewareReplaysRequestBody(t *testing.T) {
+	const body = `{"username":"jane@example.com"}`
+
+	ts := newTokenServer(t, "")
+	ts.apiHandler = func(w http.ResponseWriter, r *http.Request) {
+		got, err := io.ReadAll(r.Body)
+		if err != nil || string(got) != body {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+
+	parallelGet(t, ts, ts.middleware(t, &Token{AccessToken: "revoked", RefreshToken: "refresh"}), 1, body)
+}
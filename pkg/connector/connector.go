@@ -2,25 +2,63 @@ package connector
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
 	"github.com/conductorone/baton-sdk/pkg/annotations"
 	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// regionProbeTimeout bounds each request asking another cluster whether it accepts the credentials.
+const regionProbeTimeout = 15 * time.Second
+
 type ArubaCentral struct {
-	client *arubacentral.Client
+	client              arubacentral.Backend
+	users               *userIndex
+	allowScopeNarrowing bool
+	connectorUsername   string
+	apps                []string
+	tenancy             tenancy
+	// greenLake is set for tenants migrated to HPE GreenLake, which have no groups, sites or labels to sync.
+	greenLake bool
+
+	// baseURL and the current access token are used to find the right region when validation fails.
+	baseURL     *url.URL
+	accessToken func() string
+	probeClient *http.Client
 }
 
 // ResourceSyncers returns a ResourceSyncer for each resource type that should be synced from the upstream service.
 func (ac *ArubaCentral) ResourceSyncers(ctx context.Context) []connectorbuilder.ResourceSyncer {
-	return []connectorbuilder.ResourceSyncer{
-		newUserBuilder(ac.client),
-		newRoleBuilder(ac.client),
-		newGroupBuilder(ac.client),
+	syncers := []connectorbuilder.ResourceSyncer{
+		newUserBuilder(ac.client, ac.users, ac.tenancy, ac.connectorUsername),
+		newAppBuilder(ac.client, ac.tenancy, ac.apps),
+		newRoleBuilder(ac.client, ac.users, ac.tenancy),
+	}
+
+	if !ac.greenLake {
+		syncers = append(syncers,
+			newGroupBuilder(ac.client, ac.users, ac.tenancy, ac.allowScopeNarrowing),
+			newSiteBuilder(ac.client, ac.users, ac.tenancy),
+			newLabelBuilder(ac.client, ac.users, ac.tenancy),
+		)
+	}
+
+	if ac.tenancy.msp {
+		syncers = append([]connectorbuilder.ResourceSyncer{newTenantBuilder(ac.client)}, syncers...)
 	}
+
+	return syncers
 }
 
 // Asset takes an input AssetRef and attempts to fetch it using the connector's authenticated http client
@@ -33,30 +71,181 @@ func (ac *ArubaCentral) Asset(ctx context.Context, asset *v2.AssetRef) (string,
 func (ac *ArubaCentral) Metadata(ctx context.Context) (*v2.ConnectorMetadata, error) {
 	return &v2.ConnectorMetadata{
 		DisplayName: "ArubaCentral",
-		Description: "Connector syncing ArubaCentral users, roles and groups to Baton",
+		Description: "Connector syncing ArubaCentral users, applications, roles, groups, sites and labels to Baton",
 	}, nil
 }
 
 // Validate is called to ensure that the connector is properly configured. It should exercise any API credentials
-// to be sure that they are valid.
+// to be sure that they are valid. In MSP mode the credentials must be able to list the MSP's customers.
+// Every sync starts with it, so the user index of the last sync is dropped here.
 func (ac *ArubaCentral) Validate(ctx context.Context) (annotations.Annotations, error) {
+	ac.users.Reset()
+
 	pgVars := arubacentral.NewPaginationVars(1, 0)
+	if ac.tenancy.msp {
+		_, _, rl, err := ac.client.ListCustomers(ctx, pgVars)
+		if err != nil {
+			return annotations.New(rl), ac.regionHint(ctx, fmt.Errorf("baton-aruba-central: failed to list MSP customers: %w", err))
+		}
+
+		return annotations.New(rl), nil
+	}
+
 	_, _, rl, err := ac.client.ListUsers(ctx, pgVars)
 	if err != nil {
-		return annotations.New(rl), err
+		return annotations.New(rl), ac.regionHint(ctx, err)
 	}
 
 	return annotations.New(rl), nil
 }
 
+// regionHint points to the right region when validation failed because the credentials belong to another cluster.
+func (ac *ArubaCentral) regionHint(ctx context.Context, err error) error {
+	var accessToken string
+	if ac.accessToken != nil {
+		accessToken = ac.accessToken()
+	}
+
+	return regionHint(ctx, ac.baseURL, ac.probeClient, accessToken, err)
+}
+
+// regionHint points to the right region when the credentials were rejected because they belong to another cluster.
+// Tokens are only valid on the cluster that issued them, so the other clusters are asked which one accepts the token.
+// Without a token, as when logging in failed, the error only suggests checking the region.
+func regionHint(ctx context.Context, baseURL *url.URL, probeClient *http.Client, accessToken string, err error) error {
+	// only rejected credentials hint at another cluster, not a used up budget or an outage
+	if !errors.Is(err, arubacentral.ErrUnauthorized) {
+		return err
+	}
+
+	// only probe when talking to a cluster directly, a token issued by a proxy or local stand-in must not leave it
+	configured, ok := arubacentral.RegionForHost(baseURL.Host)
+	if !ok || baseURL.Path != "" {
+		return err
+	}
+
+	if accessToken == "" || probeClient == nil {
+		return fmt.Errorf("baton-aruba-central: the %s cluster rejected the credentials, check that --region is the cluster they were created on: %w", configured.Name, err)
+	}
+
+	l := ctxzap.Extract(ctx)
+	l.Debug(
+		"baton-aruba-central: asking the API gateways of the other clusters which one accepts the access token",
+		zap.String("region", configured.Name),
+	)
+
+	region, ok := arubacentral.ProbeRegion(ctx, probeClient, accessToken, baseURL.Host)
+	if !ok {
+		l.Debug("baton-aruba-central: no other cluster accepts the access token")
+		return err
+	}
+
+	return status.Errorf(
+		codes.FailedPrecondition,
+		"baton-aruba-central: the credentials belong to the %s cluster, not %s, set --region %s: %s",
+		region.Name,
+		configured.Name,
+		region.Name,
+		err.Error(),
+	)
+}
+
+// Config configures the connector.
+type Config struct {
+	// BaseURL is the Central API gateway of the account's cluster.
+	BaseURL *url.URL
+	// OAuth authenticates API requests. With a GreenLakeConfig, identities are synced from HPE GreenLake
+	// and groups, sites and labels from the Central API at BaseURL.
+	OAuth OAuthConfig
+	// AllowScopeNarrowing lets revoking a group narrow an all groups scope to the remaining groups.
+	AllowScopeNarrowing bool
+	// Apps limits the RBAC applications whose roles are synced, all of them if empty.
+	Apps []string
+	// MSPMode syncs customers of the MSP as tenants and all other resources within them.
+	MSPMode bool
+	// Budget, if set, caps the API calls made per day.
+	Budget *arubacentral.APIBudget
+}
+
 // New returns a new instance of the connector.
-func New(ctx context.Context, baseHost string, cfg OAuthConfig) (*ArubaCentral, error) {
+func New(ctx context.Context, config Config) (*ArubaCentral, error) {
+	baseURL, cfg, budget := config.BaseURL, config.OAuth, config.Budget
+
 	httpClient, err := cfg.GetClient(ctx)
 	if err != nil {
-		return nil, err
+		// the code flow logs in right away, with credentials of another cluster it fails here already
+		return nil, regionHint(ctx, baseURL, nil, "", err)
+	}
+
+	// recording and replaying wrap the configured flow, which still decides the backend and the connector user
+	flowCfg := cfg
+	if fixtureCfg, ok := cfg.(*FixtureConfig); ok {
+		flowCfg = fixtureCfg.OAuthConfig
+	}
+
+	// the code flow user owns the connector's token and must never be deleted through the connector
+	var connectorUsername string
+	switch c := flowCfg.(type) {
+	case *CodeFlowConfig:
+		connectorUsername = c.Username
+	case *RefreshTokenFlowConfig:
+		if c.CodeFlow != nil {
+			connectorUsername = c.CodeFlow.Username
+		}
+	}
+
+	// API calls are paced by the quota the API reports, token requests are made by the auth transport underneath.
+	// The budget sits below the pacing, so retried requests count against it as well.
+	transport := httpClient.Transport
+	if budget != nil {
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+
+		transport = budget.Transport(transport)
+	}
+
+	apiClient := *httpClient
+	apiClient.Transport = arubacentral.NewRateLimitTransport(transport)
+
+	var client arubacentral.Backend = arubacentral.NewClient(&apiClient, baseURL)
+	greenLakeCfg, greenLake := flowCfg.(*GreenLakeConfig)
+	if greenLake {
+		apiURL := greenLakeCfg.APIURL
+		if apiURL == nil {
+			apiURL = &url.URL{Scheme: "https", Host: arubacentral.GreenLakeAPIHost}
+		}
+
+		client = arubacentral.NewGreenLakeClient(&apiClient, apiURL)
+	}
+
+	var accessToken func() string
+	var probeClient *http.Client
+	if m, ok := httpClient.Transport.(*AuthMiddleware); ok {
+		accessToken = func() string {
+			token := m.currentToken()
+			if token == nil {
+				return ""
+			}
+
+			return token.AccessToken
+		}
+		probeClient = &http.Client{
+			Transport: m.Transport,
+			Timeout:   regionProbeTimeout,
+		}
 	}
 
 	return &ArubaCentral{
-		client: arubacentral.NewClient(httpClient, baseHost),
+		client:              client,
+		users:               newUserIndex(client),
+		allowScopeNarrowing: config.AllowScopeNarrowing,
+		connectorUsername:   connectorUsername,
+		apps:                config.Apps,
+		tenancy:             tenancy{msp: config.MSPMode},
+		greenLake:           greenLake,
+		baseURL:             baseURL,
+		accessToken:         accessToken,
+		probeClient:         probeClient,
 	}, nil
 }
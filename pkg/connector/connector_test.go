@@ -0,0 +1,864 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral/arubacentraltest"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
+	"github.com/conductorone/baton-sdk/pkg/dotc1z"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	sdkSync "github.com/conductorone/baton-sdk/pkg/sync"
+	"github.com/conductorone/baton-sdk/pkg/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const testUsers = 120
+
+// seedServer fills the fake with enough users to need several pages, two applications with their roles,
+// groups, sites and labels.
+func seedServer(s *arubacentraltest.Server) {
+	s.AddRoles(arubacentral.ArubaCentralApp,
+		arubacentral.Role{RoleName: "admin", Permission: "modify"},
+		arubacentral.Role{RoleName: "readonly", Permission: "view"},
+		arubacentral.Role{
+			RoleName:   "helpdesk",
+			Permission: "view",
+			Applications: []arubacentral.Application{
+				{
+					Name:       arubacentral.ArubaCentralApp,
+					Permission: "view",
+					Modules:    []arubacentral.Module{{Name: "monitoring", Permission: "modify"}},
+				},
+			},
+		},
+	)
+	s.AddRoles("account_setting", arubacentral.Role{RoleName: "account-admin", Permission: "modify"})
+	s.AddGroups("default", "branch-1", "branch-2", "branch-3", "branch-4", "branch-5")
+	s.AddSites(
+		arubacentral.Site{ID: 1, Name: "HQ", City: "Santa Clara", DeviceCount: 12},
+		arubacentral.Site{ID: 2, Name: "Warehouse", City: "Reno", DeviceCount: 3},
+	)
+	s.AddLabels(arubacentral.Label{ID: 7, Name: "floor-1", DeviceCount: 4})
+
+	s.AddUsers(arubacentral.User{
+		Username: "user-000@example.com",
+		Name:     arubacentral.UserName{First: "Ada", Last: "Admin"},
+		Applications: []arubacentral.UserApplication{
+			{
+				Name: arubacentral.ArubaCentralApp,
+				Info: []arubacentral.UserRoleInfo{
+					{Role: "admin", Scope: arubacentral.UserScope{Groups: []string{arubacentral.AllGroupsScope}}},
+				},
+			},
+		},
+	})
+
+	for i := 1; i < testUsers; i++ {
+		user := arubacentral.User{
+			Username: fmt.Sprintf("user-%03d@example.com", i),
+			Name:     arubacentral.UserName{First: "User", Last: fmt.Sprint(i)},
+			Applications: []arubacentral.UserApplication{
+				{
+					Name: arubacentral.ArubaCentralApp,
+					Info: []arubacentral.UserRoleInfo{
+						{Role: "readonly", Scope: arubacentral.UserScope{Groups: []string{"branch-1"}, Sites: []string{"1"}}},
+					},
+				},
+			},
+		}
+
+		// labels are referred to by name on some tenants
+		if i%3 == 0 {
+			user.Applications[0].Info[0].Scope.Labels = []string{"floor-1"}
+		}
+
+		if i%10 == 0 {
+			user.Applications = append(user.Applications, arubacentral.UserApplication{
+				Name: "account_setting",
+				Info: []arubacentral.UserRoleInfo{
+					{Role: "account-admin", Scope: arubacentral.UserScope{Groups: []string{arubacentral.AllGroupsScope}}},
+				},
+			})
+		}
+
+		s.AddUsers(user)
+	}
+}
+
+// connectorClient serves the connector over an in-memory gRPC connection, as the syncer expects a client.
+type connectorClient struct {
+	v2.ResourceTypesServiceClient
+	v2.ResourcesServiceClient
+	v2.EntitlementsServiceClient
+	v2.GrantsServiceClient
+	v2.ConnectorServiceClient
+	v2.AssetServiceClient
+	v2.GrantManagerServiceClient
+	v2.ResourceManagerServiceClient
+	v2.AccountManagerServiceClient
+	v2.CredentialManagerServiceClient
+	v2.EventServiceClient
+	v2.TicketsServiceClient
+}
+
+func newConnectorClient(t *testing.T, srv types.ConnectorServer) types.ConnectorClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	v2.RegisterResourceTypesServiceServer(server, srv)
+	v2.RegisterResourcesServiceServer(server, srv)
+	v2.RegisterEntitlementsServiceServer(server, srv)
+	v2.RegisterGrantsServiceServer(server, srv)
+	v2.RegisterConnectorServiceServer(server, srv)
+	v2.RegisterAssetServiceServer(server, srv)
+	v2.RegisterGrantManagerServiceServer(server, srv)
+	v2.RegisterResourceManagerServiceServer(server, srv)
+	v2.RegisterAccountManagerServiceServer(server, srv)
+	v2.RegisterCredentialManagerServiceServer(server, srv)
+	v2.RegisterEventServiceServer(server, srv)
+	v2.RegisterTicketsServiceServer(server, srv)
+
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return &connectorClient{
+		ResourceTypesServiceClient:     v2.NewResourceTypesServiceClient(conn),
+		ResourcesServiceClient:         v2.NewResourcesServiceClient(conn),
+		EntitlementsServiceClient:      v2.NewEntitlementsServiceClient(conn),
+		GrantsServiceClient:            v2.NewGrantsServiceClient(conn),
+		ConnectorServiceClient:         v2.NewConnectorServiceClient(conn),
+		AssetServiceClient:             v2.NewAssetServiceClient(conn),
+		GrantManagerServiceClient:      v2.NewGrantManagerServiceClient(conn),
+		ResourceManagerServiceClient:   v2.NewResourceManagerServiceClient(conn),
+		AccountManagerServiceClient:    v2.NewAccountManagerServiceClient(conn),
+		CredentialManagerServiceClient: v2.NewCredentialManagerServiceClient(conn),
+		EventServiceClient:             v2.NewEventServiceClient(conn),
+		TicketsServiceClient:           v2.NewTicketsServiceClient(conn),
+	}
+}
+
+// syncResult holds what a full sync wrote to the c1z file.
+type syncResult struct {
+	// resources are keyed by resource type.
+	resources map[string][]*v2.Resource
+	// grants are keyed by entitlement id, holding the principal resource ids.
+	grants map[string][]string
+}
+
+// refreshTokenFlow returns a refresh token flow configuration with a token pair freshly issued by the fake.
+func refreshTokenFlow(s *arubacentraltest.Server) *RefreshTokenFlowConfig {
+	accessToken, refreshToken := s.IssueToken()
+
+	return &RefreshTokenFlowConfig{
+		BaseConfig: BaseConfig{
+			BaseURL:      s.BaseURL(),
+			ClientID:     s.Credentials.ClientID,
+			ClientSecret: s.Credentials.ClientSecret,
+		},
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	}
+}
+
+// greenLakeConfig returns a configuration authenticating against the GreenLake endpoints of the fake.
+func greenLakeConfig(s *arubacentraltest.Server) *GreenLakeConfig {
+	return &GreenLakeConfig{
+		ClientID:     s.Credentials.ClientID,
+		ClientSecret: s.Credentials.ClientSecret,
+		TokenURL:     s.URL + arubacentraltest.GreenLakeTokenPath,
+		APIURL:       s.BaseURL(),
+	}
+}
+
+// newTestConnector creates a connector talking to the fake. Unless cfg sets them,
+// it uses the fake's base URL and the refresh token flow with a freshly issued token pair.
+func newTestConnector(t *testing.T, s *arubacentraltest.Server, cfg Config) *ArubaCentral {
+	t.Helper()
+
+	if cfg.BaseURL == nil {
+		cfg.BaseURL = s.BaseURL()
+	}
+
+	if cfg.OAuth == nil {
+		cfg.OAuth = refreshTokenFlow(s)
+	}
+
+	ac, err := New(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+
+	return ac
+}
+
+// runSync validates the connector, runs a full sync into a c1z file and reads it back.
+func runSync(t *testing.T, baseURL *url.URL, cfg OAuthConfig) *syncResult {
+	t.Helper()
+
+	ctx := context.Background()
+
+	ac, err := New(ctx, Config{BaseURL: baseURL, OAuth: cfg})
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+
+	if _, err := ac.Validate(ctx); err != nil {
+		t.Fatalf("failed to validate connector: %v", err)
+	}
+
+	c1zPath := filepath.Join(t.TempDir(), "sync.c1z")
+	if err := syncC1Z(t, ac, c1zPath); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+
+	return readC1Z(t, c1zPath)
+}
+
+// syncC1Z runs a sync into the c1z file at c1zPath, resuming the sync left unfinished in it, if any.
+func syncC1Z(t *testing.T, ac *ArubaCentral, c1zPath string) error {
+	t.Helper()
+
+	ctx := context.Background()
+
+	srv, err := connectorbuilder.NewConnector(ctx, ac)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	syncer, err := sdkSync.NewSyncer(ctx, newConnectorClient(t, srv), sdkSync.WithC1ZPath(c1zPath), sdkSync.WithTmpDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	syncErr := syncer.Sync(ctx)
+
+	// closing saves the progress of a failed sync too
+	if err := syncer.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	return syncErr
+}
+
+// readC1Z reads the resources and grants of the c1z file at c1zPath.
+func readC1Z(t *testing.T, c1zPath string) *syncResult {
+	t.Helper()
+
+	ctx := context.Background()
+
+	c1f, err := dotc1z.NewC1ZFile(ctx, c1zPath, dotc1z.WithTmpDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1f.Close()
+
+	result := &syncResult{
+		resources: make(map[string][]*v2.Resource),
+		grants:    make(map[string][]string),
+	}
+
+	pageToken := ""
+	for {
+		resp, err := c1f.ListResources(ctx, &v2.ResourcesServiceListResourcesRequest{PageToken: pageToken})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, resource := range resp.List {
+			rt := resource.Id.ResourceType
+			result.resources[rt] = append(result.resources[rt], resource)
+		}
+
+		if pageToken = resp.NextPageToken; pageToken == "" {
+			break
+		}
+	}
+
+	for {
+		resp, err := c1f.ListGrants(ctx, &v2.GrantsServiceListGrantsRequest{PageToken: pageToken})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, g := range resp.List {
+			result.grants[g.Entitlement.Id] = append(result.grants[g.Entitlement.Id], g.Principal.Id.Resource)
+		}
+
+		if pageToken = resp.NextPageToken; pageToken == "" {
+			break
+		}
+	}
+
+	return result
+}
+
+// assertSeededSync checks a sync of the data added by seedServer.
+func assertSeededSync(t *testing.T, result *syncResult) {
+	t.Helper()
+
+	wantResources := map[string]int{
+		userResourceType.Id:  testUsers,
+		appResourceType.Id:   2,
+		roleResourceType.Id:  4,
+		groupResourceType.Id: 6,
+		siteResourceType.Id:  2,
+		labelResourceType.Id: 1,
+	}
+	for rt, want := range wantResources {
+		if got := len(result.resources[rt]); got != want {
+			t.Errorf("synced %d %s resources, want %d", got, rt, want)
+		}
+	}
+
+	wantGrants := map[string]int{
+		"role:nms:admin:member":                     1,
+		"role:nms:readonly:member":                  testUsers - 1,
+		"role:nms:helpdesk:member":                  0,
+		"role:account_setting:account-admin:member": (testUsers - 1) / 10,
+		// scoped explicitly, plus everyone scoped to all groups
+		"group:branch-1:member": testUsers,
+		"group:branch-2:member": 1 + (testUsers-1)/10,
+		"site:1:access":         testUsers - 1,
+		"site:2:access":         0,
+		"label:7:access":        (testUsers - 1) / 3,
+	}
+	for entitlementID, want := range wantGrants {
+		if got := len(result.grants[entitlementID]); got != want {
+			t.Errorf("synced %d grants of %s, want %d", got, entitlementID, want)
+		}
+	}
+}
+
+func TestSyncRefreshTokenFlow(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	seedServer(s)
+
+	result := runSync(t, s.BaseURL(), refreshTokenFlow(s))
+
+	assertSeededSync(t, result)
+
+	if got := s.Requests(arubacentraltest.TokenPath); got != 0 {
+		t.Errorf("valid access token was refreshed %d times, want 0", got)
+	}
+
+	// 120 users take three pages of 50
+	if got := s.Requests(arubacentral.UsersEndpoint); got < 3 {
+		t.Errorf("listed users %d times, want at least 3 pages", got)
+	}
+}
+
+func TestSyncCodeFlow(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	seedServer(s)
+
+	result := runSync(t, s.BaseURL(), &CodeFlowConfig{
+		BaseConfig: BaseConfig{
+			BaseURL:      s.BaseURL(),
+			ClientID:     s.Credentials.ClientID,
+			ClientSecret: s.Credentials.ClientSecret,
+		},
+		Username:   s.Credentials.Username,
+		Password:   s.Credentials.Password,
+		CustomerID: s.Credentials.CustomerID,
+	})
+
+	assertSeededSync(t, result)
+
+	if got := s.Requests(arubacentraltest.LoginPath); got != 1 {
+		t.Errorf("logged in %d times, want 1", got)
+	}
+}
+
+func TestSyncRefreshesRevokedAccessToken(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	seedServer(s)
+
+	cfg := refreshTokenFlow(s)
+	s.RevokeAccessTokens()
+
+	result := runSync(t, s.BaseURL(), cfg)
+
+	assertSeededSync(t, result)
+
+	if got := s.Requests(arubacentraltest.TokenPath); got != 1 {
+		t.Errorf("token was refreshed %d times, want 1", got)
+	}
+}
+
+func TestSyncFallsBackToCodeFlow(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	seedServer(s)
+
+	accessToken, refreshToken := s.IssueToken()
+	s.RevokeAccessTokens()
+	s.RevokeRefreshTokens()
+
+	base := BaseConfig{
+		BaseURL:      s.BaseURL(),
+		ClientID:     s.Credentials.ClientID,
+		ClientSecret: s.Credentials.ClientSecret,
+	}
+
+	result := runSync(t, s.BaseURL(), &RefreshTokenFlowConfig{
+		BaseConfig:   base,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		CodeFlow: &CodeFlowConfig{
+			BaseConfig: base,
+			Username:   s.Credentials.Username,
+			Password:   s.Credentials.Password,
+			CustomerID: s.Credentials.CustomerID,
+		},
+	})
+
+	assertSeededSync(t, result)
+
+	if got := s.Requests(arubacentraltest.LoginPath); got != 1 {
+		t.Errorf("logged in %d times, want 1", got)
+	}
+}
+
+func TestValidateRejectedCredentials(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+
+	ac := newTestConnector(t, s, Config{})
+	s.RevokeAccessTokens()
+	s.RevokeRefreshTokens()
+
+	_, err := ac.Validate(context.Background())
+	if err == nil {
+		t.Fatal("validation succeeded with revoked tokens")
+	}
+
+	// rejected credentials must not look like an outage, which would be retried
+	if got := status.Code(err); got != codes.Unauthenticated {
+		t.Errorf("got %s for revoked tokens, want Unauthenticated: %v", got, err)
+	}
+}
+
+func TestValidateReportsRateLimit(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	s.SetDailyLimit(100)
+
+	ac := newTestConnector(t, s, Config{})
+
+	annos, err := ac.Validate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rl v2.RateLimitDescription
+	if ok, err := annos.Pick(&rl); err != nil || !ok {
+		t.Fatalf("validation returned no rate limit description: %v", err)
+	}
+
+	if rl.Limit != 100 || rl.Remaining != 99 || rl.Status != v2.RateLimitDescription_STATUS_OK {
+		t.Errorf("got rate limit %d/%d %s, want 99/100 OK", rl.Remaining, rl.Limit, rl.Status)
+	}
+}
+
+func TestSyncFailsOnServerError(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	seedServer(s)
+
+	ac := newTestConnector(t, s, Config{})
+
+	s.Fail(arubacentral.GroupsEndpoint, 500)
+
+	_, _, _, err := newGroupBuilder(ac.client, ac.users, ac.tenancy, false).List(context.Background(), nil, &pagination.Token{})
+	if err == nil {
+		t.Fatal("listing groups succeeded despite a server error")
+	}
+
+	if got := status.Code(err); got != codes.Internal {
+		t.Errorf("got %s for a server error, want Internal: %v", got, err)
+	}
+}
+
+func TestSyncStopsAtAPIBudgetAndResumes(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	seedServer(s)
+
+	cfg := refreshTokenFlow(s)
+
+	tmpDir := t.TempDir()
+	usagePath := filepath.Join(tmpDir, "usage.json")
+	c1zPath := filepath.Join(tmpDir, "sync.c1z")
+
+	const maxCalls = 10
+
+	budget, err := arubacentral.NewAPIBudget(usagePath, maxCalls, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ac := newTestConnector(t, s, Config{OAuth: cfg, Budget: budget})
+
+	err = syncC1Z(t, ac, c1zPath)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got sync error %v, want ResourceExhausted", err)
+	}
+
+	if got := s.UsedToday(); got != maxCalls {
+		t.Errorf("sent %d API requests, want %d", got, maxCalls)
+	}
+
+	// the budget of the previous run is still used up
+	budget, err = arubacentral.NewAPIBudget(usagePath, maxCalls, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ac = newTestConnector(t, s, Config{OAuth: cfg, Budget: budget})
+
+	if _, err := ac.Validate(context.Background()); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("got validation error %v with the budget used up by the previous run, want ResourceExhausted", err)
+	}
+
+	budget, err = arubacentral.NewAPIBudget(usagePath, 10000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ac = newTestConnector(t, s, Config{OAuth: cfg, Budget: budget})
+
+	if err := syncC1Z(t, ac, c1zPath); err != nil {
+		t.Fatalf("resumed sync failed: %v", err)
+	}
+
+	assertSeededSync(t, readC1Z(t, c1zPath))
+
+	// a resumed sync picks up where the previous run stopped, instead of starting over.
+	// Only the user index is built again, which repeats the user listing once.
+	reference := arubacentraltest.NewServer(t)
+	seedServer(reference)
+	runSync(t, reference.BaseURL(), refreshTokenFlow(reference))
+
+	if got, want := s.UsedToday(), reference.UsedToday()+reference.Requests(arubacentral.UsersEndpoint); got > want {
+		t.Errorf("sent %d API requests over both runs, a single sync and a user listing send %d", got, want)
+	}
+}
+
+func TestGroupListPagesPastClampedPageSize(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	for i := 0; i < 45; i++ {
+		s.AddGroups(fmt.Sprintf("group-%02d", i))
+	}
+
+	ac := newTestConnector(t, s, Config{})
+
+	builder := newGroupBuilder(ac.client, ac.users, ac.tenancy, false)
+
+	// the groups endpoint returns 20 groups per page, less than ResourcesPageSize
+	seen := make(map[string]bool)
+	token := &pagination.Token{}
+	for {
+		resources, next, _, err := builder.List(context.Background(), nil, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, resource := range resources {
+			seen[resource.Id.Resource] = true
+		}
+
+		if next == "" {
+			break
+		}
+
+		token = &pagination.Token{Token: next}
+	}
+
+	if len(seen) != 45 {
+		t.Errorf("listed %d groups, want 45", len(seen))
+	}
+}
+
+func TestSyncRebuildsUserIndexForEachSync(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	seedServer(s)
+
+	ac := newTestConnector(t, s, Config{})
+
+	if err := syncC1Z(t, ac, filepath.Join(t.TempDir(), "first.c1z")); err != nil {
+		t.Fatalf("first sync failed: %v", err)
+	}
+
+	s.AddUsers(arubacentral.User{
+		Username: "late@example.com",
+		Applications: []arubacentral.UserApplication{
+			{
+				Name: arubacentral.ArubaCentralApp,
+				Info: []arubacentral.UserRoleInfo{
+					{Role: "readonly", Scope: arubacentral.UserScope{Groups: []string{"branch-3"}}},
+				},
+			},
+		},
+	})
+
+	// the same connector syncs again, as a long running one does
+	c1zPath := filepath.Join(t.TempDir(), "second.c1z")
+	if err := syncC1Z(t, ac, c1zPath); err != nil {
+		t.Fatalf("second sync failed: %v", err)
+	}
+
+	grants := readC1Z(t, c1zPath).grants["group:branch-3:member"]
+	if !slices.Contains(grants, "late@example.com") {
+		t.Errorf("user added between syncs wasn't granted branch-3, got %v", grants)
+	}
+}
+
+func TestGroupGrantsFromCachedUserIndexReportNoRateLimit(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	seedServer(s)
+	s.SetDailyLimit(1000)
+
+	ac := newTestConnector(t, s, Config{})
+
+	builder := newGroupBuilder(ac.client, ac.users, ac.tenancy, false)
+	group := &v2.Resource{Id: &v2.ResourceId{ResourceType: groupResourceType.Id, Resource: "branch-1"}, DisplayName: "branch-1"}
+
+	// the first lookup builds the index and reports the rate limit of listing users
+	_, _, annos, err := builder.Grants(context.Background(), group, &pagination.Token{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rl v2.RateLimitDescription
+	if ok, err := annos.Pick(&rl); err != nil || !ok {
+		t.Fatalf("building the user index returned no rate limit description: %v", err)
+	}
+
+	_, _, annos, err = builder.Grants(context.Background(), group, &pagination.Token{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if annos.Contains(&v2.RateLimitDescription{}) {
+		t.Errorf("grants served from the cached user index reported a rate limit")
+	}
+}
+
+func TestNewHintsAtRegionWhenLoginIsRejected(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+
+	_, err := New(context.Background(), Config{
+		BaseURL: s.BaseURL(),
+		OAuth: &CodeFlowConfig{
+			BaseConfig: BaseConfig{
+				BaseURL:      s.BaseURL(),
+				ClientID:     s.Credentials.ClientID,
+				ClientSecret: s.Credentials.ClientSecret,
+			},
+			Username:   s.Credentials.Username,
+			Password:   "wrong",
+			CustomerID: s.Credentials.CustomerID,
+		},
+	})
+	if err == nil {
+		t.Fatal("created the connector with a wrong password")
+	}
+
+	// the fake isn't a known cluster, so the error is left as is
+	if got := status.Code(err); got != codes.Unauthenticated {
+		t.Errorf("got %s for a rejected login, want Unauthenticated: %v", got, err)
+	}
+
+	// against a cluster's gateway the hint names the configured region, without a token to probe the others with
+	gateway := &url.URL{Scheme: "https", Host: "apigw-uswest5.central.arubanetworks.com"}
+	err = regionHint(context.Background(), gateway, nil, "", err)
+	if got := status.Code(err); got != codes.Unauthenticated {
+		t.Errorf("got %s for a rejected login, want Unauthenticated: %v", got, err)
+	}
+
+	if !strings.Contains(err.Error(), "US-West5 cluster rejected the credentials") {
+		t.Errorf("error doesn't point at the configured region: %v", err)
+	}
+}
+
+// seedTenant adds an admin scoped to all groups and a user scoped to the first of the groups to the tenant.
+func seedTenant(tenant *arubacentraltest.Tenant, admin, user string, groups ...string) {
+	tenant.AddRoles(arubacentral.ArubaCentralApp,
+		arubacentral.Role{RoleName: "admin", Permission: "modify"},
+		arubacentral.Role{RoleName: "readonly", Permission: "view"},
+	)
+	tenant.AddGroups(groups...)
+	tenant.AddUsers(
+		arubacentral.User{
+			Username: admin,
+			Applications: []arubacentral.UserApplication{
+				{
+					Name: arubacentral.ArubaCentralApp,
+					Info: []arubacentral.UserRoleInfo{
+						{Role: "admin", Scope: arubacentral.UserScope{Groups: []string{arubacentral.AllGroupsScope}}},
+					},
+				},
+			},
+		},
+		arubacentral.User{
+			Username: user,
+			Applications: []arubacentral.UserApplication{
+				{
+					Name: arubacentral.ArubaCentralApp,
+					Info: []arubacentral.UserRoleInfo{
+						{Role: "readonly", Scope: arubacentral.UserScope{Groups: groups[:1]}},
+					},
+				},
+			},
+		},
+	)
+}
+
+func TestSyncMSPMode(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	seedTenant(s.Tenant, "admin@msp.example", "ops@msp.example", "default", "msp-only")
+	seedTenant(s.AddCustomer(arubacentral.Customer{ID: "c1", Name: "Customer One"}), "admin@one.example", "ops@one.example", "default", "store-1")
+	seedTenant(s.AddCustomer(arubacentral.Customer{ID: "c2", Name: "Customer Two"}), "admin@two.example", "ops@two.example", "default")
+
+	ac := newTestConnector(t, s, Config{MSPMode: true})
+
+	if _, err := ac.Validate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	c1zPath := filepath.Join(t.TempDir(), "sync.c1z")
+	if err := syncC1Z(t, ac, c1zPath); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+
+	result := readC1Z(t, c1zPath)
+
+	// the MSP's own users are synced at the top level, the customers' users under their tenant
+	var users []string
+	for _, user := range result.resources[userResourceType.Id] {
+		users = append(users, user.Id.Resource)
+
+		tenantID := user.GetParentResourceId().GetResource()
+		if tenantID == "" && strings.Contains(user.Id.Resource, tenantSeparator) ||
+			tenantID != "" && !strings.HasPrefix(user.Id.Resource, tenantID+tenantSeparator) {
+			t.Errorf("user %s was synced under tenant %q", user.Id.Resource, tenantID)
+		}
+	}
+	slices.Sort(users)
+
+	want := []string{
+		"admin@msp.example",
+		"c1/admin@one.example",
+		"c1/ops@one.example",
+		"c2/admin@two.example",
+		"c2/ops@two.example",
+		"ops@msp.example",
+	}
+	if !slices.Equal(users, want) {
+		t.Errorf("synced users %v, want %v", users, want)
+	}
+
+	wantResources := map[string]int{
+		tenantResourceType.Id: 2,
+		groupResourceType.Id:  5,
+		roleResourceType.Id:   6,
+	}
+	for rt, want := range wantResources {
+		if got := len(result.resources[rt]); got != want {
+			t.Errorf("synced %d %s resources, want %d", got, rt, want)
+		}
+	}
+
+	// group names repeat across tenants, their grants don't
+	wantGrants := map[string][]string{
+		"group:default:member":     {"admin@msp.example", "ops@msp.example"},
+		"role:nms:admin:member":    {"admin@msp.example"},
+		"group:c1/default:member":  {"c1/admin@one.example", "c1/ops@one.example"},
+		"group:c1/store-1:member":  {"c1/admin@one.example"},
+		"group:c2/default:member":  {"c2/admin@two.example", "c2/ops@two.example"},
+		"role:c2/nms:admin:member": {"c2/admin@two.example"},
+	}
+	for entitlementID, want := range wantGrants {
+		got := slices.Clone(result.grants[entitlementID])
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Errorf("synced grants %v of %s, want %v", got, entitlementID, want)
+		}
+	}
+}
+
+func TestSyncGreenLake(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	seedServer(s)
+
+	result := runSync(t, s.BaseURL(), greenLakeConfig(s))
+
+	wantResources := map[string]int{
+		userResourceType.Id:  testUsers,
+		appResourceType.Id:   2,
+		roleResourceType.Id:  4,
+		groupResourceType.Id: 0,
+		siteResourceType.Id:  0,
+		labelResourceType.Id: 0,
+	}
+	for rt, want := range wantResources {
+		if got := len(result.resources[rt]); got != want {
+			t.Errorf("synced %d %s resources, want %d", got, rt, want)
+		}
+	}
+
+	wantGrants := map[string]int{
+		"role:nms:admin:member":                     1,
+		"role:nms:readonly:member":                  testUsers - 1,
+		"role:account_setting:account-admin:member": (testUsers - 1) / 10,
+	}
+	for entitlementID, want := range wantGrants {
+		if got := len(result.grants[entitlementID]); got != want {
+			t.Errorf("synced %d grants of %s, want %d", got, entitlementID, want)
+		}
+	}
+
+	// nothing is fetched from the classic Central gateway
+	for _, endpoint := range []string{
+		arubacentral.UsersEndpoint,
+		arubacentral.GroupsEndpoint,
+		arubacentral.SitesEndpoint,
+		arubacentral.LabelsEndpoint,
+	} {
+		if got := s.Requests(endpoint); got != 0 {
+			t.Errorf("sent %d requests to %s, want none", got, endpoint)
+		}
+	}
+
+	// role assignments cost a call per user, which is made once per sync
+	userID := arubacentraltest.GreenLakeUserID("user-001@example.com")
+	assignmentsPath := arubacentral.GreenLakeUsersEndpoint + "/" + userID + "/" + arubacentral.GreenLakeRoleAssignmentsPath
+	if got := s.Requests(assignmentsPath); got != 1 {
+		t.Errorf("fetched the role assignments of a user %d times, want once", got)
+	}
+}
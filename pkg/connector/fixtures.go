@@ -0,0 +1,45 @@
+package connector
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
+)
+
+// FixtureConfig records the API responses of the wrapped config to a file, or replays them from it.
+// Replaying never calls the wrapped config, so no credentials are needed, but it still selects the backend.
+// The OAuth endpoints are never recorded, the recorder sits outside the token handling.
+type FixtureConfig struct {
+	OAuthConfig
+	Mode arubacentral.FixtureMode
+	Path string
+}
+
+func (cfg *FixtureConfig) GetClient(ctx context.Context) (*http.Client, error) {
+	if cfg.Mode == arubacentral.FixtureReplay {
+		transport, err := arubacentral.NewFixtureTransport(cfg.Mode, cfg.Path, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return &http.Client{Transport: transport}, nil
+	}
+
+	httpClient, err := cfg.OAuthConfig.GetClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	next := httpClient.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	transport, err := arubacentral.NewFixtureTransport(cfg.Mode, cfg.Path, next)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
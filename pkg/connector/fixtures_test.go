@@ -0,0 +1,62 @@
+package connector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral/arubacentraltest"
+)
+
+func TestFixturesRecordAndReplay(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	seedServer(s)
+
+	accessToken, refreshToken := s.IssueToken()
+	fixturesPath := filepath.Join(t.TempDir(), "fixtures.jsonl")
+
+	recorded := runSync(t, s.BaseURL(), &FixtureConfig{
+		OAuthConfig: &RefreshTokenFlowConfig{
+			BaseConfig: BaseConfig{
+				BaseURL:      s.BaseURL(),
+				ClientID:     s.Credentials.ClientID,
+				ClientSecret: s.Credentials.ClientSecret,
+			},
+			AccessToken:  accessToken,
+			RefreshToken: refreshToken,
+		},
+		Mode: arubacentral.FixtureRecord,
+		Path: fixturesPath,
+	})
+	assertSeededSync(t, recorded)
+
+	b, err := os.ReadFile(fixturesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range []string{accessToken, refreshToken, "user-000@example.com", "Ada"} {
+		if strings.Contains(string(b), secret) {
+			t.Errorf("fixtures contain %q", secret)
+		}
+	}
+
+	// nothing may reach the server while replaying
+	baseURL := s.BaseURL()
+	s.Close()
+
+	replayed := runSync(t, baseURL, &FixtureConfig{
+		OAuthConfig: &NoConfig{},
+		Mode:        arubacentral.FixtureReplay,
+		Path:        fixturesPath,
+	})
+	assertSeededSync(t, replayed)
+
+	for rt, resources := range recorded.resources {
+		if got, want := len(replayed.resources[rt]), len(resources); got != want {
+			t.Errorf("replayed %d %s resources, recorded %d", got, rt, want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package connector
 import (
 	"context"
 	"fmt"
+	"slices"
 
 	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
@@ -11,21 +12,32 @@ import (
 	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
 	"github.com/conductorone/baton-sdk/pkg/types/grant"
 	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const GroupMembershipEntitlement = "member"
 
 type groupBuilder struct {
-	client       *arubacentral.Client
+	client       arubacentral.Backend
 	resourceType *v2.ResourceType
+	users        *userIndex
+	tenancy      tenancy
+
+	// allowScopeNarrowing permits revoking a group from a user scoped to all groups,
+	// which replaces the all groups scope with an explicit list of the remaining groups.
+	allowScopeNarrowing bool
 }
 
-func groupResource(group string) (*v2.Resource, error) {
+func groupResource(scope tenantScope, group string) (*v2.Resource, error) {
 	resource, err := rs.NewGroupResource(
 		group,
 		groupResourceType,
-		group,
+		scope.resourceID(group),
 		nil,
+		scope.resourceOptions()...,
 	)
 	if err != nil {
 		return nil, err
@@ -38,21 +50,28 @@ func (g *groupBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
 	return groupResourceType
 }
 
-func (g *groupBuilder) List(ctx context.Context, _ *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+func (g *groupBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	scope, ok := g.tenancy.listScope(parentResourceID)
+	if !ok {
+		return nil, "", nil, nil
+	}
+
+	ctx = scope.context(ctx)
 	bag, offset, err := parsePageToken(pToken.Token, &v2.ResourceId{ResourceType: g.resourceType.Id})
 	if err != nil {
 		return nil, "", nil, fmt.Errorf("failed to parse page token: %w", err)
 	}
 
-	pgVars := arubacentral.NewPaginationVars(ResourcesPageSize, offset)
-	groups, total, rl, err := g.client.ListGroups(ctx, pgVars)
+	pager := arubacentral.NewPager(g.client.ListGroups, ResourcesPageSize, offset)
+	groups, err := pager.Next(ctx)
+	rl := pager.RateLimit()
 	if err != nil {
 		return nil, "", annotations.New(rl), fmt.Errorf("failed to list groups: %w", err)
 	}
 
 	var rv []*v2.Resource
 	for _, group := range groups {
-		ur, err := groupResource(group)
+		ur, err := groupResource(scope, group)
 		if err != nil {
 			return nil, "", nil, fmt.Errorf("failed to create group resource: %w", err)
 		}
@@ -60,7 +79,7 @@ func (g *groupBuilder) List(ctx context.Context, _ *v2.ResourceId, pToken *pagin
 		rv = append(rv, ur)
 	}
 
-	nextPage := prepareNextToken(offset, total)
+	nextPage := nextPageToken(pager)
 	next, err := bag.NextToken(nextPage)
 	if err != nil {
 		return nil, "", nil, fmt.Errorf("failed to prepare next page token: %w", err)
@@ -83,44 +102,224 @@ func (g *groupBuilder) Entitlements(_ context.Context, resource *v2.Resource, _
 	return rv, "", nil, nil
 }
 
-func (g *groupBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
-	bag, offset, err := parsePageToken(pToken.Token, &v2.ResourceId{ResourceType: userResourceType.Id})
+func (g *groupBuilder) Grants(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	scope, groupName, err := g.tenancy.parseResourceID(resource.Id.Resource)
 	if err != nil {
-		return nil, "", nil, fmt.Errorf("failed to parse page token: %w", err)
+		return nil, "", nil, err
 	}
 
-	pgVars := arubacentral.NewPaginationVars(ResourcesPageSize, offset)
-	users, total, rl, err := g.client.ListUsers(ctx, pgVars)
+	ctx = scope.context(ctx)
+	users, rl, err := g.users.UsersInGroup(ctx, groupName)
 	if err != nil {
-		return nil, "", annotations.New(rl), fmt.Errorf("failed to list users: %w", err)
+		return nil, "", rateLimitAnnotations(rl), fmt.Errorf("failed to look up users in group: %w", err)
 	}
 
 	var rv []*v2.Grant
 	for _, user := range users {
-		if !user.ContainsGroup(resource.Id.Resource) {
-			continue
-		}
+		// users scoped to all groups are granted every group, so reviews of a single group show them too
+		roles, globalRoles := user.RolesInGroup(groupName)
 
-		uID, err := rs.NewResourceID(userResourceType, user.Username)
+		uID, err := rs.NewResourceID(userResourceType, scope.resourceID(user.Username))
 		if err != nil {
 			return nil, "", nil, fmt.Errorf("failed to create user resource id: %w", err)
 		}
 
-		rv = append(rv, grant.NewGrant(resource, GroupMembershipEntitlement, uID))
+		// the roles held for the group are attached so membership isn't mistaken for full access
+		rv = append(rv, grant.NewGrant(resource, GroupMembershipEntitlement, uID, grant.WithGrantMetadata(groupRolesMetadata(roles, globalRoles))))
 	}
 
-	nextPage := prepareNextToken(offset, total)
-	next, err := bag.NextToken(nextPage)
+	return rv, "", rateLimitAnnotations(rl), nil
+}
+
+// Grant adds the group to the scope of the user's first role assignment.
+// Users that already have access to the group through any of their role assignments are left untouched.
+func (g *groupBuilder) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) ([]*v2.Grant, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	if principal.Id.ResourceType != userResourceType.Id {
+		l.Warn(
+			"baton-aruba-central: only users can be granted group membership",
+			zap.String("principal_type", principal.Id.ResourceType),
+			zap.String("principal_id", principal.Id.Resource),
+		)
+
+		return nil, nil, fmt.Errorf("baton-aruba-central: only users can be granted group membership")
+	}
+
+	scope, groupName, err := g.tenancy.parseResourceID(entitlement.Resource.Id.Resource)
 	if err != nil {
-		return nil, "", nil, fmt.Errorf("failed to prepare next page token: %w", err)
+		return nil, nil, err
 	}
 
-	return rv, next, annotations.New(rl), nil
+	username, err := g.tenancy.principalUsername(scope, principal.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx = scope.context(ctx)
+	user, rl, err := g.client.GetUser(ctx, username)
+	if err != nil {
+		return nil, annotations.New(rl), fmt.Errorf("baton-aruba-central: failed to get user %s: %w", username, err)
+	}
+
+	assignments := user.RoleAssignments(arubacentral.ArubaCentralApp)
+	if len(assignments) == 0 {
+		return nil, annotations.New(rl), status.Errorf(
+			codes.FailedPrecondition,
+			"baton-aruba-central: user %s has no role assignment to scope group %s to, grant a role first",
+			user.Username,
+			groupName,
+		)
+	}
+
+	for _, assignment := range assignments {
+		if assignment.Scope.HasAllGroups() || slices.Contains(assignment.Scope.Groups, groupName) {
+			l.Info(
+				"baton-aruba-central: user already has access to group",
+				zap.String("user", user.Username),
+				zap.String("group", groupName),
+				zap.String("role", assignment.Role),
+			)
+
+			return nil, annotations.New(rl), nil
+		}
+	}
+
+	assignments[0].Scope.Groups = append(assignments[0].Scope.Groups, groupName)
+
+	rl, err = g.client.UpdateUser(ctx, user)
+	g.users.Invalidate(ctx)
+	if err != nil {
+		return nil, annotations.New(rl), fmt.Errorf("baton-aruba-central: failed to add group %s to user %s: %w", groupName, user.Username, err)
+	}
+
+	return []*v2.Grant{grant.NewGrant(entitlement.Resource, GroupMembershipEntitlement, principal.Id)}, annotations.New(rl), nil
+}
+
+// Revoke removes the group from the scope of every role assignment of the user.
+// Narrowing an all groups scope is refused unless it was explicitly allowed.
+func (g *groupBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	principal := grant.Principal
+	if principal.Id.ResourceType != userResourceType.Id {
+		l.Warn(
+			"baton-aruba-central: only users can have group membership revoked",
+			zap.String("principal_type", principal.Id.ResourceType),
+			zap.String("principal_id", principal.Id.Resource),
+		)
+
+		return nil, fmt.Errorf("baton-aruba-central: only users can have group membership revoked")
+	}
+
+	scope, groupName, err := g.tenancy.parseResourceID(grant.Entitlement.Resource.Id.Resource)
+	if err != nil {
+		return nil, err
+	}
+
+	username, err := g.tenancy.principalUsername(scope, principal.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = scope.context(ctx)
+	user, rl, err := g.client.GetUser(ctx, username)
+	if err != nil {
+		return annotations.New(rl), fmt.Errorf("baton-aruba-central: failed to get user %s: %w", username, err)
+	}
+
+	var allGroups []string
+	changed := false
+	for _, assignment := range user.RoleAssignments(arubacentral.ArubaCentralApp) {
+		switch {
+		case assignment.Scope.HasAllGroups():
+			if !g.allowScopeNarrowing {
+				return annotations.New(rl), status.Errorf(
+					codes.FailedPrecondition,
+					"baton-aruba-central: user %s has access to all groups through role %s, narrowing it to a list of groups requires --allow-group-scope-narrowing",
+					user.Username,
+					assignment.Role,
+				)
+			}
+
+			if allGroups == nil {
+				allGroups, rl, err = g.listAllGroups(ctx)
+				if err != nil {
+					return annotations.New(rl), fmt.Errorf("baton-aruba-central: failed to list groups: %w", err)
+				}
+			}
+
+			l.Info(
+				"baton-aruba-central: narrowing all groups scope of user",
+				zap.String("user", user.Username),
+				zap.String("role", assignment.Role),
+				zap.String("group", groupName),
+			)
+
+			assignment.Scope.Groups = slices.DeleteFunc(slices.Clone(allGroups), func(group string) bool {
+				return group == groupName
+			})
+		case slices.Contains(assignment.Scope.Groups, groupName):
+			assignment.Scope.Groups = slices.DeleteFunc(assignment.Scope.Groups, func(group string) bool {
+				return group == groupName
+			})
+		default:
+			// assignments without the group are left as they are, whatever their scope
+			continue
+		}
+
+		changed = true
+		if len(assignment.Scope.Groups) == 0 {
+			return annotations.New(rl), status.Errorf(
+				codes.FailedPrecondition,
+				"baton-aruba-central: revoking group %s would leave role %s of user %s without any group, revoke the role instead",
+				groupName,
+				assignment.Role,
+				user.Username,
+			)
+		}
+	}
+
+	if !changed {
+		l.Info(
+			"baton-aruba-central: user does not have access to group",
+			zap.String("user", user.Username),
+			zap.String("group", groupName),
+		)
+
+		return annotations.New(rl), nil
+	}
+
+	rl, err = g.client.UpdateUser(ctx, user)
+	g.users.Invalidate(ctx)
+	if err != nil {
+		return annotations.New(rl), fmt.Errorf("baton-aruba-central: failed to remove group %s from user %s: %w", groupName, user.Username, err)
+	}
+
+	return annotations.New(rl), nil
+}
+
+// listAllGroups pages through all groups in Aruba Central, or in the tenant of the context in MSP mode.
+func (g *groupBuilder) listAllGroups(ctx context.Context) ([]string, *v2.RateLimitDescription, error) {
+	return arubacentral.ListAll(ctx, g.client.ListGroups, ResourcesPageSize)
+}
+
+// groupRolesMetadata describes the roles a user holds for a group.
+// Grants coming from an all groups scope are marked as global.
+func groupRolesMetadata(roles, globalRoles []string) map[string]interface{} {
+	metadata := rolesMetadata(roles)
+	metadata["global_roles"] = stringValues(globalRoles)
+	metadata["global_scope"] = len(globalRoles) > 0
+
+	return metadata
 }
 
-func newGroupBuilder(client *arubacentral.Client) *groupBuilder {
+func newGroupBuilder(client arubacentral.Backend, users *userIndex, tenancy tenancy, allowScopeNarrowing bool) *groupBuilder {
 	return &groupBuilder{
-		client:       client,
-		resourceType: groupResourceType,
+		client:              client,
+		resourceType:        groupResourceType,
+		users:               users,
+		tenancy:             tenancy,
+		allowScopeNarrowing: allowScopeNarrowing,
 	}
 }
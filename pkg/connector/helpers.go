@@ -1,13 +1,18 @@
 package connector
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
 
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
 	"github.com/conductorone/baton-sdk/pkg/annotations"
 	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/conductorone/baton-sdk/pkg/types/grant"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 const ResourcesPageSize uint = 50
@@ -18,6 +23,15 @@ func annotationsForUserResourceType() annotations.Annotations {
 	return annos
 }
 
+// rateLimitAnnotations annotates a response with the rate limit, if a request was made to report one.
+func rateLimitAnnotations(rl *v2.RateLimitDescription) annotations.Annotations {
+	if rl == nil {
+		return nil
+	}
+
+	return annotations.New(rl)
+}
+
 func parsePageToken(i string, resourceID *v2.ResourceId) (*pagination.Bag, uint, error) {
 	b := &pagination.Bag{}
 	err := b.Unmarshal(i)
@@ -54,26 +68,112 @@ func convertPageToken(token string) (uint, error) {
 	return uint(page), nil
 }
 
-// prepareNextToken prepares the next page token.
-// List responses return number of total items across all pages.
-// Offset is zero based index of items, not pages.
-// This means we have to increase the offset by the number of items per page to get to the next page.
-func prepareNextToken(offset, total uint) string {
-	var token string
+// nextPageToken returns the token of the page after the pager's last one, or an empty token after the last page.
+// The token is the offset of the next item, not a page number.
+func nextPageToken[T any](pager *arubacentral.Pager[T]) string {
+	if pager.Done() {
+		return ""
+	}
+
+	return fmt.Sprint(pager.Offset())
+}
+
+// roleResourceID builds the id of a role resource. Role names are only unique within an application.
+func roleResourceID(appName, roleName string) string {
+	return fmt.Sprintf("%s:%s", appName, roleName)
+}
+
+// parseRoleResourceID splits the id of a role resource into application and role name.
+func parseRoleResourceID(id string) (string, string, error) {
+	appName, roleName, ok := strings.Cut(id, ":")
+	if !ok || appName == "" || roleName == "" {
+		return "", "", fmt.Errorf("baton-aruba-central: invalid role resource id %s", id)
+	}
+
+	return appName, roleName, nil
+}
+
+// getProfileStringSlice returns the values of a profile field holding either a list of strings
+// or a single comma separated string.
+func getProfileStringSlice(profile *structpb.Struct, k string) []string {
+	if profile == nil {
+		return nil
+	}
+
+	v, ok := profile.Fields[k]
+	if !ok {
+		return nil
+	}
+
+	var rv []string
+	switch value := v.Kind.(type) {
+	case *structpb.Value_StringValue:
+		for _, item := range strings.Split(value.StringValue, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				rv = append(rv, item)
+			}
+		}
+	case *structpb.Value_ListValue:
+		for _, item := range value.ListValue.Values {
+			if s, ok := item.Kind.(*structpb.Value_StringValue); ok && s.StringValue != "" {
+				rv = append(rv, s.StringValue)
+			}
+		}
+	}
+
+	return rv
+}
+
+// scopeGrants grants the entitlement of a site or label to the users scoped to it, with the roles they hold there.
+// Scopes may refer to the resource by id or by name, so both are looked up.
+func scopeGrants(
+	ctx context.Context,
+	tenancy tenancy,
+	resource *v2.Resource,
+	entitlement string,
+	lookup func(ctx context.Context, keys ...string) ([]arubacentral.User, *v2.RateLimitDescription, error),
+	rolesIn func(user *arubacentral.User, keys ...string) []string,
+) ([]*v2.Grant, annotations.Annotations, error) {
+	scope, id, err := tenancy.parseResourceID(resource.Id.Resource)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx = scope.context(ctx)
+	keys := []string{id, resource.DisplayName}
 
-	if total == 0 {
-		return token
+	users, rl, err := lookup(ctx, keys...)
+	if err != nil {
+		return nil, rateLimitAnnotations(rl), fmt.Errorf("failed to look up users in %s: %w", resource.Id.ResourceType, err)
 	}
 
-	newOffset := offset + ResourcesPageSize
-	if newOffset >= total {
-		return token
+	var rv []*v2.Grant
+	for _, user := range users {
+		uID, err := rs.NewResourceID(userResourceType, scope.resourceID(user.Username))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create user resource id: %w", err)
+		}
+
+		roles := rolesIn(&user, keys...) // #nosec G601
+		rv = append(rv, grant.NewGrant(resource, entitlement, uID, grant.WithGrantMetadata(rolesMetadata(roles))))
 	}
 
-	return fmt.Sprint(newOffset)
+	return rv, rateLimitAnnotations(rl), nil
+}
+
+// rolesMetadata describes the roles a user holds for a group, site or label.
+func rolesMetadata(roles []string) map[string]interface{} {
+	return map[string]interface{}{
+		"roles": stringValues(roles),
+	}
 }
 
-func slugify(s string) string {
-	lower := strings.ToLower(s)
-	return strings.ReplaceAll(lower, " ", "-")
+// stringValues converts strings for grant metadata, which only takes untyped lists.
+func stringValues(values []string) []interface{} {
+	rv := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		rv = append(rv, v)
+	}
+
+	return rv
 }
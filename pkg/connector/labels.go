@@ -0,0 +1,123 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+)
+
+const LabelAccessEntitlement = "access"
+
+type labelBuilder struct {
+	client       arubacentral.Backend
+	resourceType *v2.ResourceType
+	users        *userIndex
+	tenancy      tenancy
+}
+
+// labelResource carries the number of devices covered by the label, so reviewers can judge its blast radius.
+func labelResource(scope tenantScope, label *arubacentral.Label) (*v2.Resource, error) {
+	profile := map[string]interface{}{
+		"label_id":     label.ID,
+		"label_name":   label.Name,
+		"category_id":  label.CategoryID,
+		"device_count": label.DeviceCount,
+	}
+
+	resource, err := rs.NewGroupResource(
+		label.Name,
+		labelResourceType,
+		scope.resourceID(strconv.Itoa(label.ID)),
+		[]rs.GroupTraitOption{
+			rs.WithGroupProfile(profile),
+		},
+		scope.resourceOptions()...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return resource, nil
+}
+
+func (l *labelBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
+	return labelResourceType
+}
+
+func (l *labelBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	scope, ok := l.tenancy.listScope(parentResourceID)
+	if !ok {
+		return nil, "", nil, nil
+	}
+
+	ctx = scope.context(ctx)
+	bag, offset, err := parsePageToken(pToken.Token, &v2.ResourceId{ResourceType: l.resourceType.Id})
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("failed to parse page token: %w", err)
+	}
+
+	pager := arubacentral.NewPager(l.client.ListLabels, ResourcesPageSize, offset)
+	labels, err := pager.Next(ctx)
+	rl := pager.RateLimit()
+	if err != nil {
+		return nil, "", annotations.New(rl), fmt.Errorf("failed to list labels: %w", err)
+	}
+
+	var rv []*v2.Resource
+	for _, label := range labels {
+		resource, err := labelResource(scope, &label) // #nosec G601
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("failed to create label resource: %w", err)
+		}
+
+		rv = append(rv, resource)
+	}
+
+	nextPage := nextPageToken(pager)
+	next, err := bag.NextToken(nextPage)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("failed to prepare next page token: %w", err)
+	}
+
+	return rv, next, annotations.New(rl), nil
+}
+
+func (l *labelBuilder) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	var rv []*v2.Entitlement
+
+	assignmentOptions := []ent.EntitlementOption{
+		ent.WithGrantableTo(userResourceType),
+		ent.WithDisplayName(fmt.Sprintf("%s label %s", resource.DisplayName, LabelAccessEntitlement)),
+		ent.WithDescription(fmt.Sprintf("%s label %s in Aruba Central", resource.DisplayName, LabelAccessEntitlement)),
+	}
+
+	rv = append(rv, ent.NewAssignmentEntitlement(resource, LabelAccessEntitlement, assignmentOptions...))
+
+	return rv, "", nil, nil
+}
+
+// Grants returns access grants of users scoped to the label.
+func (l *labelBuilder) Grants(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	rv, annos, err := scopeGrants(ctx, l.tenancy, resource, LabelAccessEntitlement, l.users.UsersInLabel, (*arubacentral.User).RolesInLabel)
+	if err != nil {
+		return nil, "", annos, err
+	}
+
+	return rv, "", annos, nil
+}
+
+func newLabelBuilder(client arubacentral.Backend, users *userIndex, tenancy tenancy) *labelBuilder {
+	return &labelBuilder{
+		client:       client,
+		resourceType: labelResourceType,
+		users:        users,
+		tenancy:      tenancy,
+	}
+}
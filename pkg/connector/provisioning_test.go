@@ -0,0 +1,555 @@
+package connector
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral/arubacentraltest"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
+	"github.com/conductorone/baton-sdk/pkg/types"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// newTestConnectorServer serves a connector talking to the fake, so provisioning goes through the SDK's routing.
+func newTestConnectorServer(t *testing.T, s *arubacentraltest.Server, cfg Config) types.ConnectorServer {
+	t.Helper()
+
+	srv, err := connectorbuilder.NewConnector(context.Background(), newTestConnector(t, s, cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return srv
+}
+
+func testResource(resourceType *v2.ResourceType, id string) *v2.Resource {
+	return &v2.Resource{
+		Id:          &v2.ResourceId{ResourceType: resourceType.Id, Resource: id},
+		DisplayName: id,
+	}
+}
+
+// grantRequest asks to grant the entitlement of the resource to the user.
+func grantRequest(resource *v2.Resource, slug, username string) *v2.GrantManagerServiceGrantRequest {
+	return &v2.GrantManagerServiceGrantRequest{
+		Principal:   testResource(userResourceType, username),
+		Entitlement: ent.NewAssignmentEntitlement(resource, slug),
+	}
+}
+
+// revokeRequest asks to revoke the entitlement of the resource from the user.
+func revokeRequest(resource *v2.Resource, slug, username string) *v2.GrantManagerServiceRevokeRequest {
+	return &v2.GrantManagerServiceRevokeRequest{
+		Grant: &v2.Grant{
+			Principal:   testResource(userResourceType, username),
+			Entitlement: ent.NewAssignmentEntitlement(resource, slug),
+		},
+	}
+}
+
+// userGroups returns the group scope of the user's role in the Aruba Central application.
+func userGroups(t *testing.T, s *arubacentraltest.Server, username, role string) []string {
+	t.Helper()
+
+	user, ok := s.User(username)
+	if !ok {
+		t.Fatalf("user %s doesn't exist", username)
+	}
+
+	for _, assignment := range user.RoleAssignments(arubacentral.ArubaCentralApp) {
+		if assignment.Role == role {
+			return assignment.Scope.Groups
+		}
+	}
+
+	t.Fatalf("user %s doesn't hold role %s", username, role)
+
+	return nil
+}
+
+func TestGroupGrantAndRevoke(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	seedServer(s)
+
+	ctx := context.Background()
+	srv := newTestConnectorServer(t, s, Config{})
+	group := testResource(groupResourceType, "branch-2")
+
+	resp, err := srv.Grant(ctx, grantRequest(group, GroupMembershipEntitlement, "user-001@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.Grants) != 1 {
+		t.Errorf("granting a group returned %d grants, want 1", len(resp.Grants))
+	}
+
+	if got := userGroups(t, s, "user-001@example.com", "readonly"); !slices.Equal(got, []string{"branch-1", "branch-2"}) {
+		t.Errorf("user is scoped to %v after the grant, want branch-1 and branch-2", got)
+	}
+
+	// granting it again changes nothing
+	resp, err = srv.Grant(ctx, grantRequest(group, GroupMembershipEntitlement, "user-001@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.Grants) != 0 {
+		t.Errorf("granting a group the user already has returned %d grants, want none", len(resp.Grants))
+	}
+
+	if _, err := srv.Revoke(ctx, revokeRequest(group, GroupMembershipEntitlement, "user-001@example.com")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := userGroups(t, s, "user-001@example.com", "readonly"); !slices.Equal(got, []string{"branch-1"}) {
+		t.Errorf("user is scoped to %v after the revoke, want branch-1", got)
+	}
+
+	// the last group of a role can't be revoked
+	_, err = srv.Revoke(ctx, revokeRequest(testResource(groupResourceType, "branch-1"), GroupMembershipEntitlement, "user-001@example.com"))
+	if got := status.Code(err); got != codes.FailedPrecondition {
+		t.Errorf("got %s revoking the last group of a role, want FailedPrecondition: %v", got, err)
+	}
+}
+
+func TestGroupRevokeLeavesOtherAssignments(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	seedServer(s)
+	s.AddUsers(arubacentral.User{
+		Username: "site-admin@example.com",
+		Applications: []arubacentral.UserApplication{
+			{
+				Name: arubacentral.ArubaCentralApp,
+				Info: []arubacentral.UserRoleInfo{
+					{Role: "readonly", Scope: arubacentral.UserScope{Groups: []string{"branch-1", "branch-2"}}},
+					{Role: "helpdesk", Scope: arubacentral.UserScope{Sites: []string{"1"}}},
+				},
+			},
+		},
+	})
+
+	// the site scoped role holds no group, which doesn't block revoking a group of the other role
+	revoke := revokeRequest(testResource(groupResourceType, "branch-2"), GroupMembershipEntitlement, "site-admin@example.com")
+	if _, err := newTestConnectorServer(t, s, Config{}).Revoke(context.Background(), revoke); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := userGroups(t, s, "site-admin@example.com", "readonly"); !slices.Equal(got, []string{"branch-1"}) {
+		t.Errorf("user is scoped to %v after the revoke, want branch-1", got)
+	}
+
+	user, _ := s.User("site-admin@example.com")
+	if got := user.RolesInSite("1"); !slices.Equal(got, []string{"helpdesk"}) {
+		t.Errorf("user holds %v for the site after the revoke, want helpdesk", got)
+	}
+}
+
+func TestGroupRevokeNarrowsAllGroupsScope(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	seedServer(s)
+
+	ctx := context.Background()
+	revoke := revokeRequest(testResource(groupResourceType, "branch-1"), GroupMembershipEntitlement, "user-000@example.com")
+
+	_, err := newTestConnectorServer(t, s, Config{}).Revoke(ctx, revoke)
+	if got := status.Code(err); got != codes.FailedPrecondition {
+		t.Errorf("got %s narrowing an all groups scope without it being allowed, want FailedPrecondition: %v", got, err)
+	}
+
+	if got := userGroups(t, s, "user-000@example.com", "admin"); !slices.Equal(got, []string{arubacentral.AllGroupsScope}) {
+		t.Errorf("refused revoke changed the scope to %v", got)
+	}
+
+	if _, err := newTestConnectorServer(t, s, Config{AllowScopeNarrowing: true}).Revoke(ctx, revoke); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"default", "branch-2", "branch-3", "branch-4", "branch-5"}
+	if got := userGroups(t, s, "user-000@example.com", "admin"); !slices.Equal(got, want) {
+		t.Errorf("user is scoped to %v after narrowing, want %v", got, want)
+	}
+}
+
+func TestRoleGrantAndRevoke(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	seedServer(s)
+
+	ctx := context.Background()
+	srv := newTestConnectorServer(t, s, Config{})
+	role := testResource(roleResourceType, roleResourceID(arubacentral.ArubaCentralApp, "helpdesk"))
+
+	if _, err := srv.Grant(ctx, grantRequest(role, RoleMembershipEntitlement, "user-001@example.com")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the new assignment inherits the whole scope of the user's other role
+	if got := userGroups(t, s, "user-001@example.com", "helpdesk"); !slices.Equal(got, []string{"branch-1"}) {
+		t.Errorf("granted role is scoped to %v, want branch-1", got)
+	}
+
+	user, _ := s.User("user-001@example.com")
+	for _, assignment := range user.RoleAssignments(arubacentral.ArubaCentralApp) {
+		if !slices.Equal(assignment.Scope.Sites, []string{"1"}) {
+			t.Errorf("role %s is scoped to sites %v, want 1", assignment.Role, assignment.Scope.Sites)
+		}
+	}
+
+	// without a role in the application there's no scope to take, all groups isn't granted implicitly
+	accountAdmin := testResource(roleResourceType, roleResourceID("account_setting", "account-admin"))
+	_, err := srv.Grant(ctx, grantRequest(accountAdmin, RoleMembershipEntitlement, "user-001@example.com"))
+	if got := status.Code(err); got != codes.FailedPrecondition {
+		t.Errorf("got %s granting a role without a scope to take, want FailedPrecondition: %v", got, err)
+	}
+
+	if got, _ := s.Role(arubacentral.ArubaCentralApp, "helpdesk"); !slices.Equal(got.Users, []string{"user-001@example.com"}) {
+		t.Errorf("role is held by %v, want only the granted user", got.Users)
+	}
+
+	// the fake refuses roles that don't exist, like Central
+	missing := testResource(roleResourceType, roleResourceID(arubacentral.ArubaCentralApp, "missing"))
+	_, err = srv.Grant(ctx, grantRequest(missing, RoleMembershipEntitlement, "user-001@example.com"))
+	if got := status.Code(err); got != codes.InvalidArgument {
+		t.Errorf("got %s granting a role that doesn't exist, want InvalidArgument: %v", got, err)
+	}
+
+	if _, err := srv.Revoke(ctx, revokeRequest(role, RoleMembershipEntitlement, "user-001@example.com")); err != nil {
+		t.Fatal(err)
+	}
+
+	user, _ = s.User("user-001@example.com")
+	if user.HasRole(arubacentral.ArubaCentralApp, "helpdesk") || !user.HasRole(arubacentral.ArubaCentralApp, "readonly") {
+		t.Errorf("user holds %+v after the revoke, want only readonly", user.Applications)
+	}
+}
+
+func TestCreateAccount(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	seedServer(s)
+
+	ctx := context.Background()
+	srv := newTestConnectorServer(t, s, Config{})
+
+	profile, err := structpb.NewStruct(map[string]interface{}{
+		"first_name": "New",
+		"last_name":  "Hire",
+		"role":       "readonly",
+		"groups":     []interface{}{"branch-2", "branch-3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &v2.CreateAccountRequest{
+		AccountInfo: &v2.AccountInfo{
+			Emails:  []*v2.AccountInfo_Email{{Address: "new.hire@example.com", IsPrimary: true}},
+			Profile: profile,
+		},
+	}
+
+	resp, err := srv.CreateAccount(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := resp.GetSuccess().GetResource().GetId().GetResource(); got != "new.hire@example.com" {
+		t.Errorf("created user resource %s, want new.hire@example.com", got)
+	}
+
+	user, ok := s.User("new.hire@example.com")
+	if !ok {
+		t.Fatal("user wasn't created")
+	}
+
+	if user.Name.First != "New" || user.Name.Last != "Hire" {
+		t.Errorf("user was created with name %+v", user.Name)
+	}
+
+	if got := userGroups(t, s, "new.hire@example.com", "readonly"); !slices.Equal(got, []string{"branch-2", "branch-3"}) {
+		t.Errorf("user was created scoped to %v, want branch-2 and branch-3", got)
+	}
+
+	_, err = srv.CreateAccount(ctx, req)
+	if got := status.Code(err); got != codes.AlreadyExists {
+		t.Errorf("got %s creating an existing user, want AlreadyExists: %v", got, err)
+	}
+
+	// without a role nothing is sent to Central
+	delete(profile.Fields, "role")
+	req.AccountInfo.Emails[0].Address = "no.role@example.com"
+	_, err = srv.CreateAccount(ctx, req)
+	if got := status.Code(err); got != codes.InvalidArgument {
+		t.Errorf("got %s creating a user without a role, want InvalidArgument: %v", got, err)
+	}
+
+	if _, ok := s.User("no.role@example.com"); ok {
+		t.Error("user without a role was created")
+	}
+}
+
+func TestCreateAccountInMSPTenant(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	tenant := s.AddCustomer(arubacentral.Customer{ID: "c1", Name: "Customer One"})
+	seedTenant(tenant, "admin@one.example", "ops@one.example", "default")
+
+	srv := newTestConnectorServer(t, s, Config{MSPMode: true})
+
+	profile := map[string]interface{}{
+		"role":      "readonly",
+		"groups":    []interface{}{"default"},
+		"tenant_id": "c1",
+	}
+
+	accountInfo := func() *v2.AccountInfo {
+		p, err := structpb.NewStruct(profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return &v2.AccountInfo{Login: "new@one.example", Profile: p}
+	}
+
+	resp, err := srv.CreateAccount(context.Background(), &v2.CreateAccountRequest{AccountInfo: accountInfo()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := resp.GetSuccess().GetResource().GetId().GetResource(); got != "c1/new@one.example" {
+		t.Errorf("created user resource %s, want c1/new@one.example", got)
+	}
+
+	if _, ok := tenant.User("new@one.example"); !ok {
+		t.Error("user wasn't created in the tenant")
+	}
+
+	if _, ok := s.User("new@one.example"); ok {
+		t.Error("user was created in the MSP account")
+	}
+
+	// the gateway refuses tenants that aren't customers of the MSP
+	profile["tenant_id"] = "unknown"
+	_, err = srv.CreateAccount(context.Background(), &v2.CreateAccountRequest{AccountInfo: accountInfo()})
+	if got := status.Code(err); got != codes.PermissionDenied {
+		t.Errorf("got %s creating a user in an unknown tenant, want PermissionDenied: %v", got, err)
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	seedServer(s)
+
+	ctx := context.Background()
+	srv := newTestConnectorServer(t, s, Config{})
+	req := &v2.DeleteResourceRequest{
+		ResourceId: &v2.ResourceId{ResourceType: userResourceType.Id, Resource: "user-005@example.com"},
+	}
+
+	if _, err := srv.DeleteResource(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s.User("user-005@example.com"); ok {
+		t.Error("user wasn't deleted")
+	}
+
+	// offboarding can be repeated
+	if _, err := srv.DeleteResource(ctx, req); err != nil {
+		t.Errorf("deleting a deleted user failed: %v", err)
+	}
+}
+
+func TestDeleteUserRefusesConnectorUser(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	seedServer(s)
+	s.AddUsers(arubacentral.User{Username: s.Credentials.Username})
+
+	srv := newTestConnectorServer(t, s, Config{
+		OAuth: &CodeFlowConfig{
+			BaseConfig: BaseConfig{
+				BaseURL:      s.BaseURL(),
+				ClientID:     s.Credentials.ClientID,
+				ClientSecret: s.Credentials.ClientSecret,
+			},
+			Username:   s.Credentials.Username,
+			Password:   s.Credentials.Password,
+			CustomerID: s.Credentials.CustomerID,
+		},
+	})
+
+	_, err := srv.DeleteResource(context.Background(), &v2.DeleteResourceRequest{
+		ResourceId: &v2.ResourceId{ResourceType: userResourceType.Id, Resource: s.Credentials.Username},
+	})
+	if got := status.Code(err); got != codes.FailedPrecondition {
+		t.Errorf("got %s deleting the connector's user, want FailedPrecondition: %v", got, err)
+	}
+
+	if _, ok := s.User(s.Credentials.Username); !ok {
+		t.Error("connector's user was deleted")
+	}
+}
+
+// roleDefinition returns a role resource to create in the Aruba Central application.
+func roleDefinition(t *testing.T, roleName, permission string, modules map[string]interface{}) *v2.Resource {
+	t.Helper()
+
+	appID, err := rs.NewResourceID(appResourceType, arubacentral.ArubaCentralApp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resource, err := rs.NewRoleResource(
+		roleName,
+		roleResourceType,
+		roleResourceID(arubacentral.ArubaCentralApp, roleName),
+		[]rs.RoleTraitOption{
+			rs.WithRoleProfile(map[string]interface{}{"permission": permission, "modules": modules}),
+		},
+		rs.WithParentResourceID(appID),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resource
+}
+
+func TestCreateUpdateAndDeleteRole(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	seedServer(s)
+
+	ctx := context.Background()
+	srv := newTestConnectorServer(t, s, Config{})
+
+	// the role name is a path segment, which must be escaped once
+
+	resp, err := srv.CreateResource(ctx, &v2.CreateResourceRequest{
+		Resource: roleDefinition(t, "Network Auditor", "view", map[string]interface{}{"monitoring": "view"}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := resp.Created.GetId().GetResource(); got != "nms:Network Auditor" {
+		t.Errorf("created role resource %s, want nms:Network Auditor", got)
+	}
+
+	role, ok := s.Role(arubacentral.ArubaCentralApp, "Network Auditor")
+	if !ok {
+		t.Fatal("role wasn't created")
+	}
+
+	if role.Permission != "view" || len(role.Applications) != 1 || len(role.Applications[0].Modules) != 1 {
+		t.Errorf("role was created as %+v", role)
+	}
+
+	// creating it again updates its definition
+	_, err = srv.CreateResource(ctx, &v2.CreateResourceRequest{
+		Resource: roleDefinition(t, "Network Auditor", "modify", map[string]interface{}{"monitoring": "modify", "reports": "view"}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	role, _ = s.Role(arubacentral.ArubaCentralApp, "Network Auditor")
+	if role.Permission != "modify" || len(role.Applications[0].Modules) != 2 {
+		t.Errorf("role was updated to %+v", role)
+	}
+
+	_, err = srv.CreateResource(ctx, &v2.CreateResourceRequest{Resource: roleDefinition(t, "admin", "view", nil)})
+	if got := status.Code(err); got != codes.FailedPrecondition {
+		t.Errorf("got %s changing a system role, want FailedPrecondition: %v", got, err)
+	}
+
+	req := &v2.DeleteResourceRequest{ResourceId: &v2.ResourceId{ResourceType: roleResourceType.Id, Resource: "nms:Network Auditor"}}
+	if _, err := srv.DeleteResource(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s.Role(arubacentral.ArubaCentralApp, "Network Auditor"); ok {
+		t.Error("role wasn't deleted")
+	}
+
+	if _, err := srv.DeleteResource(ctx, req); err != nil {
+		t.Errorf("deleting a deleted role failed: %v", err)
+	}
+
+	_, err = srv.DeleteResource(ctx, &v2.DeleteResourceRequest{ResourceId: &v2.ResourceId{ResourceType: roleResourceType.Id, Resource: "nms:admin"}})
+	if got := status.Code(err); got != codes.FailedPrecondition {
+		t.Errorf("got %s deleting a system role, want FailedPrecondition: %v", got, err)
+	}
+
+	if _, ok := s.Role(arubacentral.ArubaCentralApp, "admin"); !ok {
+		t.Error("system role was deleted")
+	}
+}
+
+func TestGreenLakeProvisioning(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	seedServer(s)
+
+	ctx := context.Background()
+	srv := newTestConnectorServer(t, s, Config{OAuth: greenLakeConfig(s)})
+	role := testResource(roleResourceType, roleResourceID(arubacentral.ArubaCentralApp, "helpdesk"))
+
+	if _, err := srv.Grant(ctx, grantRequest(role, RoleMembershipEntitlement, "user-001@example.com")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := userGroups(t, s, "user-001@example.com", "helpdesk"); !slices.Equal(got, []string{"branch-1"}) {
+		t.Errorf("granted role is scoped to %v, want branch-1", got)
+	}
+
+	if _, err := srv.Revoke(ctx, revokeRequest(role, RoleMembershipEntitlement, "user-001@example.com")); err != nil {
+		t.Fatal(err)
+	}
+
+	if user, _ := s.User("user-001@example.com"); user.HasRole(arubacentral.ArubaCentralApp, "helpdesk") {
+		t.Error("role wasn't revoked")
+	}
+
+	profile, err := structpb.NewStruct(map[string]interface{}{
+		"role":   "readonly",
+		"groups": []interface{}{arubacentral.AllGroupsScope},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = srv.CreateAccount(ctx, &v2.CreateAccountRequest{
+		AccountInfo: &v2.AccountInfo{Login: "invited@example.com", Profile: profile},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the invited user gets the role with an all groups scope, which GreenLake leaves unscoped
+	if got := userGroups(t, s, "invited@example.com", "readonly"); !slices.Equal(got, []string{arubacentral.AllGroupsScope}) {
+		t.Errorf("invited user is scoped to %v, want all groups", got)
+	}
+
+	req := &v2.DeleteResourceRequest{ResourceId: &v2.ResourceId{ResourceType: userResourceType.Id, Resource: "invited@example.com"}}
+	if _, err := srv.DeleteResource(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s.User("invited@example.com"); ok {
+		t.Error("user wasn't deleted")
+	}
+
+	if _, err := srv.DeleteResource(ctx, req); err != nil {
+		t.Errorf("deleting a deleted user failed: %v", err)
+	}
+
+	// custom roles are managed in the GreenLake console
+	_, err = srv.CreateResource(ctx, &v2.CreateResourceRequest{Resource: roleDefinition(t, "auditor", "view", nil)})
+	if got := status.Code(err); got != codes.Unimplemented {
+		t.Errorf("got %s creating a role in GreenLake, want Unimplemented: %v", got, err)
+	}
+}
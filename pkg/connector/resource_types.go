@@ -17,9 +17,28 @@ var (
 		DisplayName: "Role",
 		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_ROLE},
 	}
+	appResourceType = &v2.ResourceType{
+		Id:          "application",
+		DisplayName: "Application",
+		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_APP},
+	}
 	groupResourceType = &v2.ResourceType{
 		Id:          "group",
 		DisplayName: "Group",
 		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_GROUP},
 	}
+	siteResourceType = &v2.ResourceType{
+		Id:          "site",
+		DisplayName: "Site",
+		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_GROUP},
+	}
+	labelResourceType = &v2.ResourceType{
+		Id:          "label",
+		DisplayName: "Label",
+		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_GROUP},
+	}
+	tenantResourceType = &v2.ResourceType{
+		Id:          "tenant",
+		DisplayName: "Tenant",
+	}
 )
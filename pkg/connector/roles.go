@@ -3,6 +3,7 @@ package connector
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
@@ -12,29 +13,45 @@ import (
 	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
 	"github.com/conductorone/baton-sdk/pkg/types/grant"
 	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 const RoleMembershipEntitlement = "member"
 
 type roleBuilder struct {
-	client       *arubacentral.Client
+	client       arubacentral.Backend
 	resourceType *v2.ResourceType
+	users        *userIndex
+	tenancy      tenancy
 }
 
-func roleResource(role *arubacentral.Role) (*v2.Resource, error) {
+func roleResource(scope tenantScope, appName string, role *arubacentral.Role) (*v2.Resource, error) {
 	profile := map[string]interface{}{
+		"app_name":    appName,
 		"role_name":   role.RoleName,
 		"no_of_users": role.NoOfUsers,
 		"users":       strings.Join(role.Users, ","),
+		"permission":  role.Permission,
+		"system_role": arubacentral.IsSystemRole(role.RoleName),
+	}
+
+	appID, err := rs.NewResourceID(appResourceType, scope.resourceID(appName))
+	if err != nil {
+		return nil, err
 	}
 
 	resource, err := rs.NewRoleResource(
 		role.RoleName,
 		roleResourceType,
-		slugify(role.RoleName),
+		scope.resourceID(roleResourceID(appName, role.RoleName)),
 		[]rs.RoleTraitOption{
 			rs.WithRoleProfile(profile),
 		},
+		rs.WithParentResourceID(appID),
 	)
 	if err != nil {
 		return nil, err
@@ -47,21 +64,37 @@ func (r *roleBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
 	return roleResourceType
 }
 
-func (r *roleBuilder) List(ctx context.Context, _ *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+// List returns roles of the parent application. Roles are only synced as children of applications.
+func (r *roleBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	if parentResourceID == nil || parentResourceID.ResourceType != appResourceType.Id {
+		return nil, "", nil, nil
+	}
+
 	bag, offset, err := parsePageToken(pToken.Token, &v2.ResourceId{ResourceType: r.resourceType.Id})
 	if err != nil {
 		return nil, "", nil, fmt.Errorf("failed to parse page token: %w", err)
 	}
 
-	pgVars := arubacentral.NewPaginationVars(ResourcesPageSize, offset)
-	roles, total, rl, err := r.client.ListRoles(ctx, pgVars)
+	scope, appName, err := r.tenancy.parseResourceID(parentResourceID.Resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	ctx = scope.context(ctx)
+	listRoles := func(ctx context.Context, pgVars *arubacentral.PaginationVars) ([]arubacentral.Role, uint, *v2.RateLimitDescription, error) {
+		return r.client.ListRoles(ctx, appName, pgVars)
+	}
+
+	pager := arubacentral.NewPager(listRoles, ResourcesPageSize, offset)
+	roles, err := pager.Next(ctx)
+	rl := pager.RateLimit()
 	if err != nil {
 		return nil, "", annotations.New(rl), fmt.Errorf("failed to list roles: %w", err)
 	}
 
 	var rv []*v2.Resource
 	for _, role := range roles {
-		resource, err := roleResource(&role) // #nosec G601
+		resource, err := roleResource(scope, appName, &role) // #nosec G601
 		if err != nil {
 			return nil, "", nil, fmt.Errorf("failed to create role resource: %w", err)
 		}
@@ -69,7 +102,7 @@ func (r *roleBuilder) List(ctx context.Context, _ *v2.ResourceId, pToken *pagina
 		rv = append(rv, resource)
 	}
 
-	nextToken := prepareNextToken(offset, total)
+	nextToken := nextPageToken(pager)
 	next, err := bag.NextToken(nextToken)
 	if err != nil {
 		return nil, "", nil, fmt.Errorf("failed to create next token: %w", err)
@@ -90,7 +123,14 @@ func (r *roleBuilder) Entitlements(ctx context.Context, resource *v2.Resource, _
 
 	rv = append(rv, ent.NewAssignmentEntitlement(resource, RoleMembershipEntitlement, assignmentOptions...))
 
-	roleDetail, rl, err := r.client.GetRole(ctx, resource.DisplayName)
+	scope, appName, roleName, err := r.parseRoleID(resource.Id.Resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	ctx = scope.context(ctx)
+
+	roleDetail, rl, err := r.client.GetRole(ctx, appName, roleName)
 	if err != nil {
 		return nil, "", annotations.New(rl), fmt.Errorf("failed to get role details: %w", err)
 	}
@@ -124,44 +164,376 @@ func (r *roleBuilder) Entitlements(ctx context.Context, resource *v2.Resource, _
 	return rv, "", annotations.New(rl), nil
 }
 
+// Grants returns role grants scoped to the groups the users hold the role for.
+// The scope is attached to each grant as grant metadata.
 func (r *roleBuilder) Grants(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
-	roleDetail, rl, err := r.client.GetRole(ctx, resource.DisplayName)
+	scope, appName, roleName, err := r.parseRoleID(resource.Id.Resource)
 	if err != nil {
-		return nil, "", annotations.New(rl), fmt.Errorf("failed to get role details: %w", err)
+		return nil, "", nil, err
 	}
 
-	if roleDetail.NoOfUsers == 0 {
-		return nil, "", annotations.New(rl), nil
+	ctx = scope.context(ctx)
+
+	roleDetail, rl, err := r.client.GetRole(ctx, appName, roleName)
+	if err != nil {
+		return nil, "", rateLimitAnnotations(rl), fmt.Errorf("failed to get role details: %w", err)
+	}
+
+	// a cached index reports no rate limit, the one of the role request is still current then
+	users, usersRL, err := r.users.UsersWithRole(ctx, appName, roleName)
+	if usersRL != nil {
+		rl = usersRL
+	}
+	if err != nil {
+		return nil, "", rateLimitAnnotations(rl), fmt.Errorf("failed to look up users with role: %w", err)
 	}
 
 	var rv []*v2.Grant
-	for _, userID := range roleDetail.Users {
-		uID, err := rs.NewResourceID(userResourceType, userID)
+	for _, user := range users {
+		groups, ok := user.RoleScope(appName, roleName)
+		if !ok {
+			continue
+		}
+
+		uID, err := rs.NewResourceID(userResourceType, scope.resourceID(user.Username))
 		if err != nil {
 			return nil, "", nil, fmt.Errorf("failed to create user resource id: %w", err)
 		}
 
+		scope := grant.WithGrantMetadata(roleScopeMetadata(appName, groups))
+
 		// membership grants
-		rv = append(rv, grant.NewGrant(resource, RoleMembershipEntitlement, uID))
+		rv = append(rv, grant.NewGrant(resource, RoleMembershipEntitlement, uID, scope))
 
 		// permission grants
 		for _, app := range roleDetail.Applications {
 			appEntitlementName := fmt.Sprintf("%s-%s", app.Name, app.Permission)
-			rv = append(rv, grant.NewGrant(resource, appEntitlementName, uID))
+			rv = append(rv, grant.NewGrant(resource, appEntitlementName, uID, scope))
 
 			for _, module := range app.Modules {
 				moduleEntitlementName := fmt.Sprintf("%s-%s", appEntitlementName, module.Name)
-				rv = append(rv, grant.NewGrant(resource, moduleEntitlementName, uID))
+				rv = append(rv, grant.NewGrant(resource, moduleEntitlementName, uID, scope))
 			}
 		}
 	}
 
-	return rv, "", annotations.New(rl), nil
+	return rv, "", rateLimitAnnotations(rl), nil
+}
+
+func (r *roleBuilder) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) ([]*v2.Grant, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	if principal.Id.ResourceType != userResourceType.Id {
+		l.Warn(
+			"baton-aruba-central: only users can be granted role membership",
+			zap.String("principal_type", principal.Id.ResourceType),
+			zap.String("principal_id", principal.Id.Resource),
+		)
+
+		return nil, nil, fmt.Errorf("baton-aruba-central: only users can be granted role membership")
+	}
+
+	if entitlement.Slug != RoleMembershipEntitlement {
+		return nil, nil, fmt.Errorf("baton-aruba-central: only %s entitlement can be granted on roles", RoleMembershipEntitlement)
+	}
+
+	scope, appName, roleName, err := r.parseRoleID(entitlement.Resource.Id.Resource)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	username, err := r.tenancy.principalUsername(scope, principal.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx = scope.context(ctx)
+	user, rl, err := r.client.GetUser(ctx, username)
+	if err != nil {
+		return nil, annotations.New(rl), fmt.Errorf("baton-aruba-central: failed to get user %s: %w", username, err)
+	}
+
+	// the role takes the scope of the user's other roles, a user without any is left for an admin to scope
+	assignments := user.RoleAssignments(appName)
+	if len(assignments) == 0 {
+		return nil, annotations.New(rl), status.Errorf(
+			codes.FailedPrecondition,
+			"baton-aruba-central: user %s has no role assignment in %s to take the scope of role %s from",
+			user.Username,
+			appName,
+			roleName,
+		)
+	}
+
+	if !user.AddRole(appName, roleName, assignments[0].Scope.Clone()) {
+		l.Info(
+			"baton-aruba-central: user already has role",
+			zap.String("user", user.Username),
+			zap.String("role", roleName),
+		)
+
+		return nil, annotations.New(rl), nil
+	}
+
+	rl, err = r.client.UpdateUser(ctx, user)
+	r.users.Invalidate(ctx)
+	if err != nil {
+		return nil, annotations.New(rl), fmt.Errorf("baton-aruba-central: failed to add role %s to user %s: %w", roleName, user.Username, err)
+	}
+
+	return []*v2.Grant{grant.NewGrant(entitlement.Resource, RoleMembershipEntitlement, principal.Id)}, annotations.New(rl), nil
+}
+
+func (r *roleBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	principal := grant.Principal
+	entitlement := grant.Entitlement
+
+	if principal.Id.ResourceType != userResourceType.Id {
+		l.Warn(
+			"baton-aruba-central: only users can have role membership revoked",
+			zap.String("principal_type", principal.Id.ResourceType),
+			zap.String("principal_id", principal.Id.Resource),
+		)
+
+		return nil, fmt.Errorf("baton-aruba-central: only users can have role membership revoked")
+	}
+
+	if entitlement.Slug != RoleMembershipEntitlement {
+		return nil, fmt.Errorf("baton-aruba-central: only %s entitlement can be revoked on roles", RoleMembershipEntitlement)
+	}
+
+	scope, appName, roleName, err := r.parseRoleID(entitlement.Resource.Id.Resource)
+	if err != nil {
+		return nil, err
+	}
+
+	username, err := r.tenancy.principalUsername(scope, principal.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = scope.context(ctx)
+	user, rl, err := r.client.GetUser(ctx, username)
+	if err != nil {
+		return annotations.New(rl), fmt.Errorf("baton-aruba-central: failed to get user %s: %w", username, err)
+	}
+
+	if !user.RemoveRole(appName, roleName) {
+		l.Info(
+			"baton-aruba-central: user does not have role",
+			zap.String("user", user.Username),
+			zap.String("role", roleName),
+		)
+
+		return annotations.New(rl), nil
+	}
+
+	rl, err = r.client.UpdateUser(ctx, user)
+	r.users.Invalidate(ctx)
+	if err != nil {
+		return annotations.New(rl), fmt.Errorf("baton-aruba-central: failed to remove role %s from user %s: %w", roleName, user.Username, err)
+	}
+
+	return annotations.New(rl), nil
+}
+
+// Create creates a custom role in the parent application from the role definition in the resource profile.
+// If the custom role already exists, its definition is updated instead.
+// The profile holds the app permission in "permission" and per-module permissions in "modules",
+// a map of module names to permissions. In MSP mode the parent application is required to tell the tenant.
+func (r *roleBuilder) Create(ctx context.Context, resource *v2.Resource) (*v2.Resource, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	var scope tenantScope
+	appName := arubacentral.ArubaCentralApp
+	if parent := resource.GetParentResourceId(); parent != nil && parent.ResourceType == appResourceType.Id {
+		var err error
+		scope, appName, err = r.tenancy.parseResourceID(parent.Resource)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if r.tenancy.msp {
+		return nil, nil, status.Error(codes.InvalidArgument, "baton-aruba-central: parent application is required to create a role in MSP mode")
+	}
+
+	ctx = scope.context(ctx)
+
+	role, err := roleFromResource(appName, resource)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if arubacentral.IsSystemRole(role.RoleName) {
+		return nil, nil, status.Errorf(codes.FailedPrecondition, "baton-aruba-central: system role %s can't be changed", role.RoleName)
+	}
+
+	_, rl, err := r.client.GetRole(ctx, appName, role.RoleName)
+	switch {
+	case err == nil:
+		l.Info("baton-aruba-central: role already exists, updating its definition", zap.String("role", role.RoleName))
+
+		rl, err = r.client.UpdateRole(ctx, appName, role)
+		if err != nil {
+			return nil, annotations.New(rl), fmt.Errorf("baton-aruba-central: failed to update role %s: %w", role.RoleName, err)
+		}
+	case status.Code(err) == codes.NotFound:
+		rl, err = r.client.CreateRole(ctx, appName, role)
+		if err != nil {
+			return nil, annotations.New(rl), fmt.Errorf("baton-aruba-central: failed to create role %s: %w", role.RoleName, err)
+		}
+	default:
+		return nil, annotations.New(rl), fmt.Errorf("baton-aruba-central: failed to get role %s: %w", role.RoleName, err)
+	}
+
+	created, rl, err := r.client.GetRole(ctx, appName, role.RoleName)
+	if err != nil {
+		return nil, annotations.New(rl), fmt.Errorf("baton-aruba-central: failed to get role %s: %w", role.RoleName, err)
+	}
+
+	if created.RoleName == "" {
+		created.RoleName = role.RoleName
+	}
+
+	rv, err := roleResource(scope, appName, created)
+	if err != nil {
+		return nil, annotations.New(rl), fmt.Errorf("baton-aruba-central: failed to create role resource: %w", err)
+	}
+
+	return rv, annotations.New(rl), nil
+}
+
+// Delete deletes a custom role. Roles that no longer exist are treated as deleted.
+func (r *roleBuilder) Delete(ctx context.Context, resourceId *v2.ResourceId) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	if resourceId.ResourceType != roleResourceType.Id {
+		return nil, fmt.Errorf("baton-aruba-central: unexpected resource type %s for role deletion", resourceId.ResourceType)
+	}
+
+	scope, appName, roleName, err := r.parseRoleID(resourceId.Resource)
+	if err != nil {
+		return nil, err
+	}
+
+	if arubacentral.IsSystemRole(roleName) {
+		return nil, status.Errorf(codes.FailedPrecondition, "baton-aruba-central: system role %s can't be deleted", roleName)
+	}
+
+	ctx = scope.context(ctx)
+
+	rl, err := r.client.DeleteRole(ctx, appName, roleName)
+	r.users.Invalidate(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			l.Info("baton-aruba-central: role already deleted", zap.String("role", roleName))
+
+			return annotations.New(rl), nil
+		}
+
+		return annotations.New(rl), fmt.Errorf("baton-aruba-central: failed to delete role %s: %w", roleName, err)
+	}
+
+	return annotations.New(rl), nil
+}
+
+// parseRoleID splits a role resource id into its tenant scope, application and role name.
+func (r *roleBuilder) parseRoleID(id string) (tenantScope, string, string, error) {
+	scope, localID, err := r.tenancy.parseResourceID(id)
+	if err != nil {
+		return tenantScope{}, "", "", err
+	}
+
+	appName, roleName, err := parseRoleResourceID(localID)
+	if err != nil {
+		return tenantScope{}, "", "", err
+	}
+
+	return scope, appName, roleName, nil
+}
+
+// roleFromResource maps the role definition from the resource profile onto an Aruba Central role.
+func roleFromResource(appName string, resource *v2.Resource) (*arubacentral.Role, error) {
+	roleName := resource.GetDisplayName()
+	if roleName == "" {
+		return nil, status.Error(codes.InvalidArgument, "baton-aruba-central: role name is required")
+	}
+
+	roleTrait, err := rs.GetRoleTrait(resource)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "baton-aruba-central: role definition is required: %s", err.Error())
+	}
+
+	profile := roleTrait.GetProfile()
+	permission, ok := rs.GetProfileStringValue(profile, "permission")
+	if !ok || permission == "" {
+		return nil, status.Error(codes.InvalidArgument, "baton-aruba-central: role permission is required")
+	}
+
+	var modules []arubacentral.Module
+	if modulesValue, ok := profile.GetFields()["modules"]; ok {
+		modulesStruct, ok := modulesValue.GetKind().(*structpb.Value_StructValue)
+		if !ok {
+			return nil, status.Error(codes.InvalidArgument, "baton-aruba-central: role modules must be a map of module names to permissions")
+		}
+
+		for moduleName, modulePermission := range modulesStruct.StructValue.GetFields() {
+			p, ok := modulePermission.GetKind().(*structpb.Value_StringValue)
+			if !ok {
+				return nil, status.Errorf(codes.InvalidArgument, "baton-aruba-central: permission of module %s must be a string", moduleName)
+			}
+
+			modules = append(modules, arubacentral.Module{
+				Name:       moduleName,
+				Permission: p.StringValue,
+			})
+		}
+	}
+
+	// keep the request stable regardless of map ordering
+	slices.SortFunc(modules, func(a, b arubacentral.Module) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return &arubacentral.Role{
+		RoleName:   roleName,
+		Permission: permission,
+		Applications: []arubacentral.Application{
+			{
+				Name:       appName,
+				Permission: permission,
+				Modules:    modules,
+			},
+		},
+	}, nil
+}
+
+// roleScopeMetadata describes the groups a role is held for, so reviewers see the role together with its scope.
+func roleScopeMetadata(appName string, groups []string) map[string]interface{} {
+	scopedGroups := make([]interface{}, 0, len(groups))
+	allGroups := false
+	for _, group := range groups {
+		if group == arubacentral.AllGroupsScope {
+			allGroups = true
+			continue
+		}
+
+		scopedGroups = append(scopedGroups, group)
+	}
+
+	return map[string]interface{}{
+		"app_name":   appName,
+		"all_groups": allGroups,
+		"groups":     scopedGroups,
+	}
 }
 
-func newRoleBuilder(client *arubacentral.Client) *roleBuilder {
+func newRoleBuilder(client arubacentral.Backend, users *userIndex, tenancy tenancy) *roleBuilder {
 	return &roleBuilder{
 		client:       client,
 		resourceType: roleResourceType,
+		users:        users,
+		tenancy:      tenancy,
 	}
 }
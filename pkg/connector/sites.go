@@ -0,0 +1,126 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+)
+
+const SiteAccessEntitlement = "access"
+
+type siteBuilder struct {
+	client       arubacentral.Backend
+	resourceType *v2.ResourceType
+	users        *userIndex
+	tenancy      tenancy
+}
+
+func siteResource(scope tenantScope, site *arubacentral.Site) (*v2.Resource, error) {
+	profile := map[string]interface{}{
+		"site_id":      site.ID,
+		"site_name":    site.Name,
+		"address":      site.Address,
+		"city":         site.City,
+		"state":        site.State,
+		"country":      site.Country,
+		"zipcode":      site.ZipCode,
+		"device_count": site.DeviceCount,
+	}
+
+	resource, err := rs.NewGroupResource(
+		site.Name,
+		siteResourceType,
+		scope.resourceID(strconv.Itoa(site.ID)),
+		[]rs.GroupTraitOption{
+			rs.WithGroupProfile(profile),
+		},
+		scope.resourceOptions()...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return resource, nil
+}
+
+func (s *siteBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
+	return siteResourceType
+}
+
+func (s *siteBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	scope, ok := s.tenancy.listScope(parentResourceID)
+	if !ok {
+		return nil, "", nil, nil
+	}
+
+	ctx = scope.context(ctx)
+	bag, offset, err := parsePageToken(pToken.Token, &v2.ResourceId{ResourceType: s.resourceType.Id})
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("failed to parse page token: %w", err)
+	}
+
+	pager := arubacentral.NewPager(s.client.ListSites, ResourcesPageSize, offset)
+	sites, err := pager.Next(ctx)
+	rl := pager.RateLimit()
+	if err != nil {
+		return nil, "", annotations.New(rl), fmt.Errorf("failed to list sites: %w", err)
+	}
+
+	var rv []*v2.Resource
+	for _, site := range sites {
+		resource, err := siteResource(scope, &site) // #nosec G601
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("failed to create site resource: %w", err)
+		}
+
+		rv = append(rv, resource)
+	}
+
+	nextPage := nextPageToken(pager)
+	next, err := bag.NextToken(nextPage)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("failed to prepare next page token: %w", err)
+	}
+
+	return rv, next, annotations.New(rl), nil
+}
+
+func (s *siteBuilder) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	var rv []*v2.Entitlement
+
+	assignmentOptions := []ent.EntitlementOption{
+		ent.WithGrantableTo(userResourceType),
+		ent.WithDisplayName(fmt.Sprintf("%s site %s", resource.DisplayName, SiteAccessEntitlement)),
+		ent.WithDescription(fmt.Sprintf("%s site %s in Aruba Central", resource.DisplayName, SiteAccessEntitlement)),
+	}
+
+	rv = append(rv, ent.NewAssignmentEntitlement(resource, SiteAccessEntitlement, assignmentOptions...))
+
+	return rv, "", nil, nil
+}
+
+// Grants returns access grants of users scoped to the site.
+func (s *siteBuilder) Grants(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	rv, annos, err := scopeGrants(ctx, s.tenancy, resource, SiteAccessEntitlement, s.users.UsersInSite, (*arubacentral.User).RolesInSite)
+	if err != nil {
+		return nil, "", annos, err
+	}
+
+	return rv, "", annos, nil
+}
+
+func newSiteBuilder(client arubacentral.Backend, users *userIndex, tenancy tenancy) *siteBuilder {
+	return &siteBuilder{
+		client:       client,
+		resourceType: siteResourceType,
+		users:        users,
+		tenancy:      tenancy,
+	}
+}
@@ -0,0 +1,198 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// tenantSeparator separates the tenant id from the id of a resource synced in MSP mode.
+const tenantSeparator = "/"
+
+// tenancy scopes resources to MSP tenants.
+// In MSP mode resources of a tenant are synced as its descendants and their ids are prefixed with the tenant id,
+// since names are only unique within a tenant. The MSP's own resources are synced at the top level with plain ids.
+type tenancy struct {
+	msp bool
+}
+
+// tenantScope identifies the tenant resources belong to. The zero value is the account itself, the MSP's own in MSP mode.
+type tenantScope struct {
+	msp      bool
+	tenantID string
+}
+
+// listScope returns the scope of resources listed under the parent, and false if nothing should be listed under it.
+// The account's resources are listed at the top level, in MSP mode tenant resources are listed under their tenant.
+func (t tenancy) listScope(parentResourceID *v2.ResourceId) (tenantScope, bool) {
+	if parentResourceID == nil {
+		return tenantScope{}, true
+	}
+
+	if !t.msp || parentResourceID.ResourceType != tenantResourceType.Id {
+		return tenantScope{}, false
+	}
+
+	return tenantScope{msp: true, tenantID: parentResourceID.Resource}, true
+}
+
+// parseResourceID splits a resource id into its tenant scope and the id within the tenant.
+// Ids without a tenant prefix belong to the account.
+func (t tenancy) parseResourceID(id string) (tenantScope, string, error) {
+	if !t.msp || !strings.Contains(id, tenantSeparator) {
+		return tenantScope{}, id, nil
+	}
+
+	tenantID, localID, _ := strings.Cut(id, tenantSeparator)
+	if tenantID == "" || localID == "" {
+		return tenantScope{}, "", fmt.Errorf("baton-aruba-central: resource id %s is not scoped to a tenant", id)
+	}
+
+	return tenantScope{msp: true, tenantID: tenantID}, localID, nil
+}
+
+// scope returns the scope of the given tenant.
+func (t tenancy) scope(tenantID string) tenantScope {
+	if !t.msp {
+		return tenantScope{}
+	}
+
+	return tenantScope{msp: true, tenantID: tenantID}
+}
+
+// principalUsername returns the username of a user principal, which must belong to the tenant of the scope.
+func (t tenancy) principalUsername(scope tenantScope, principalID *v2.ResourceId) (string, error) {
+	principalScope, username, err := t.parseResourceID(principalID.Resource)
+	if err != nil {
+		return "", err
+	}
+
+	if principalScope != scope {
+		return "", status.Errorf(
+			codes.InvalidArgument,
+			"baton-aruba-central: user %s belongs to tenant %s, not %s",
+			username,
+			principalScope.tenantID,
+			scope.tenantID,
+		)
+	}
+
+	return username, nil
+}
+
+// context returns a context making client requests on behalf of the tenant.
+func (s tenantScope) context(ctx context.Context) context.Context {
+	if !s.msp {
+		return ctx
+	}
+
+	return arubacentral.WithTenant(ctx, s.tenantID)
+}
+
+// resourceID scopes the id to the tenant.
+func (s tenantScope) resourceID(id string) string {
+	if !s.msp {
+		return id
+	}
+
+	return s.tenantID + tenantSeparator + id
+}
+
+// resourceOptions places top level resources of the tenant under the tenant resource.
+func (s tenantScope) resourceOptions() []rs.ResourceOption {
+	if !s.msp {
+		return nil
+	}
+
+	return []rs.ResourceOption{
+		rs.WithParentResourceID(&v2.ResourceId{ResourceType: tenantResourceType.Id, Resource: s.tenantID}),
+	}
+}
+
+type tenantBuilder struct {
+	client       arubacentral.Backend
+	resourceType *v2.ResourceType
+}
+
+func tenantResource(customer *arubacentral.Customer) (*v2.Resource, error) {
+	resource, err := rs.NewResource(
+		customer.Name,
+		tenantResourceType,
+		customer.ID,
+		rs.WithDescription(customer.Description),
+		rs.WithAnnotation(
+			&v2.ChildResourceType{ResourceTypeId: userResourceType.Id},
+			&v2.ChildResourceType{ResourceTypeId: appResourceType.Id},
+			&v2.ChildResourceType{ResourceTypeId: groupResourceType.Id},
+			&v2.ChildResourceType{ResourceTypeId: siteResourceType.Id},
+			&v2.ChildResourceType{ResourceTypeId: labelResourceType.Id},
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return resource, nil
+}
+
+func (t *tenantBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
+	return tenantResourceType
+}
+
+// List returns the customers of the MSP as tenant resources.
+func (t *tenantBuilder) List(ctx context.Context, _ *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	bag, offset, err := parsePageToken(pToken.Token, &v2.ResourceId{ResourceType: t.resourceType.Id})
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("failed to parse page token: %w", err)
+	}
+
+	pager := arubacentral.NewPager(t.client.ListCustomers, ResourcesPageSize, offset)
+	customers, err := pager.Next(ctx)
+	rl := pager.RateLimit()
+	if err != nil {
+		return nil, "", annotations.New(rl), fmt.Errorf("failed to list customers: %w", err)
+	}
+
+	var rv []*v2.Resource
+	for _, customer := range customers {
+		resource, err := tenantResource(&customer) // #nosec G601
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("failed to create tenant resource: %w", err)
+		}
+
+		rv = append(rv, resource)
+	}
+
+	nextPage := nextPageToken(pager)
+	next, err := bag.NextToken(nextPage)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("failed to prepare next page token: %w", err)
+	}
+
+	return rv, next, annotations.New(rl), nil
+}
+
+// Entitlements always returns an empty slice for tenants, access is granted within them.
+func (t *tenantBuilder) Entitlements(_ context.Context, _ *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+// Grants always returns an empty slice for tenants since they don't have any entitlements.
+func (t *tenantBuilder) Grants(_ context.Context, _ *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+func newTenantBuilder(client arubacentral.Backend) *tenantBuilder {
+	return &tenantBuilder{
+		client:       client,
+		resourceType: tenantResourceType,
+	}
+}
@@ -0,0 +1,145 @@
+package connector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
+)
+
+// TokenStore persists OAuth tokens, so refresh tokens rotated by the token endpoint survive restarts.
+type TokenStore interface {
+	// Load returns the stored token, or nil if none was stored yet.
+	Load(ctx context.Context) (*Token, error)
+	// Save replaces the stored token.
+	Save(ctx context.Context, token *Token) error
+}
+
+// FileTokenStore stores the token as plain JSON in a file readable only by its owner.
+type FileTokenStore struct {
+	Path string
+}
+
+func NewFileTokenStore(path string) *FileTokenStore {
+	return &FileTokenStore{
+		Path: path,
+	}
+}
+
+func (s *FileTokenStore) Load(_ context.Context) (*Token, error) {
+	b, err := os.ReadFile(s.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("baton-aruba-central: failed to read token store: %w", err)
+	}
+
+	return decodeToken(b)
+}
+
+func (s *FileTokenStore) Save(_ context.Context, token *Token) error {
+	b, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	return writeTokenStore(s.Path, b)
+}
+
+// AgeTokenStore stores the token in a file encrypted with an age X25519 identity, as generated by age-keygen.
+type AgeTokenStore struct {
+	Path     string
+	identity *age.X25519Identity
+}
+
+// NewAgeTokenStore returns a token store encrypting the token with the given age secret key.
+func NewAgeTokenStore(path, secretKey string) (*AgeTokenStore, error) {
+	identity, err := age.ParseX25519Identity(strings.TrimSpace(secretKey))
+	if err != nil {
+		return nil, fmt.Errorf("baton-aruba-central: invalid age secret key: %w", err)
+	}
+
+	return &AgeTokenStore{
+		Path:     path,
+		identity: identity,
+	}, nil
+}
+
+func (s *AgeTokenStore) Load(_ context.Context) (*Token, error) {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("baton-aruba-central: failed to read token store: %w", err)
+	}
+
+	defer f.Close()
+
+	r, err := age.Decrypt(f, s.identity)
+	if err != nil {
+		return nil, fmt.Errorf("baton-aruba-central: failed to decrypt token store: %w", err)
+	}
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("baton-aruba-central: failed to decrypt token store: %w", err)
+	}
+
+	return decodeToken(b)
+}
+
+func (s *AgeTokenStore) Save(_ context.Context, token *Token) error {
+	b, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, s.identity.Recipient())
+	if err != nil {
+		return fmt.Errorf("baton-aruba-central: failed to encrypt token: %w", err)
+	}
+
+	if _, err := w.Write(b); err != nil {
+		return fmt.Errorf("baton-aruba-central: failed to encrypt token: %w", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("baton-aruba-central: failed to encrypt token: %w", err)
+	}
+
+	return writeTokenStore(s.Path, buf.Bytes())
+}
+
+func decodeToken(b []byte) (*Token, error) {
+	var token Token
+	if err := json.Unmarshal(b, &token); err != nil {
+		return nil, fmt.Errorf("baton-aruba-central: failed to decode stored token: %w", err)
+	}
+
+	if token.RefreshToken == "" {
+		return nil, nil
+	}
+
+	return &token, nil
+}
+
+// writeTokenStore replaces the token store atomically, so a crash mid-write never leaves a truncated token behind.
+func writeTokenStore(path string, b []byte) error {
+	if err := arubacentral.WriteFileAtomic(path, b); err != nil {
+		return fmt.Errorf("baton-aruba-central: failed to write token store: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package connector
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral/arubacentraltest"
+)
+
+func newAgeTestStore(t *testing.T, path string) *AgeTokenStore {
+	t.Helper()
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewAgeTokenStore(path, identity.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+func TestTokenStoresRoundTrip(t *testing.T) {
+	stores := map[string]func(t *testing.T, path string) TokenStore{
+		"file": func(_ *testing.T, path string) TokenStore { return NewFileTokenStore(path) },
+		"age":  func(t *testing.T, path string) TokenStore { return newAgeTestStore(t, path) },
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+			path := filepath.Join(dir, "token.json")
+			store := newStore(t, path)
+
+			// nothing stored yet
+			token, err := store.Load(ctx)
+			if err != nil || token != nil {
+				t.Fatalf("got token %v, error %v from a missing file, want neither", token, err)
+			}
+
+			first := &Token{AccessToken: "access-1", RefreshToken: "refresh-1", ExpiresIn: time.Now().Add(time.Hour).UTC()}
+			second := &Token{AccessToken: "access-2", RefreshToken: "refresh-2", ExpiresIn: time.Now().Add(2 * time.Hour).UTC(), Origin: tokenOrigin("refresh-0")}
+			for _, want := range []*Token{first, second} {
+				if err := store.Save(ctx, want); err != nil {
+					t.Fatal(err)
+				}
+
+				got, err := store.Load(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got.AccessToken != want.AccessToken || got.RefreshToken != want.RefreshToken || !got.ExpiresIn.Equal(want.ExpiresIn) || got.Origin != want.Origin {
+					t.Errorf("loaded %+v, want %+v", got, want)
+				}
+			}
+
+			// the token is written to a temporary file renamed over the store, which leaves nothing else behind
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(entries) != 1 {
+				t.Errorf("found %d files next to the token store, want only the store", len(entries))
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if mode := info.Mode().Perm(); mode != 0o600 {
+				t.Errorf("token store has mode %o, want 600", mode)
+			}
+		})
+	}
+}
+
+func TestAgeTokenStoreEncryptsToken(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "token.age")
+
+	if err := newAgeTestStore(t, path).Save(ctx, &Token{AccessToken: "access", RefreshToken: "refresh-secret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(b, []byte("refresh-secret")) {
+		t.Error("age token store holds the refresh token in plain text")
+	}
+
+	if _, err := newAgeTestStore(t, path).Load(ctx); err == nil {
+		t.Error("loaded the token store with another key")
+	}
+}
+
+func TestTokenStoresRejectCorruptFile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "corrupt")
+	if err := os.WriteFile(path, []byte("{\"access_token\": "), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewFileTokenStore(path).Load(ctx); err == nil {
+		t.Error("file token store loaded a truncated token")
+	}
+
+	if _, err := newAgeTestStore(t, path).Load(ctx); err == nil {
+		t.Error("age token store loaded a file that isn't encrypted")
+	}
+}
+
+// refreshTokenFlowToken returns the token the refresh token flow starts with.
+func refreshTokenFlowToken(t *testing.T, cfg *RefreshTokenFlowConfig) *Token {
+	t.Helper()
+
+	client, err := cfg.GetClient(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client.Transport.(*AuthMiddleware).currentToken()
+}
+
+func TestRefreshTokenFlowPrefersChangedRefreshToken(t *testing.T) {
+	s := arubacentraltest.NewServer(t)
+	cfg := refreshTokenFlow(s)
+	refreshToken := cfg.RefreshToken
+	s.RevokeAccessTokens()
+
+	store := NewFileTokenStore(filepath.Join(t.TempDir(), "token.json"))
+	cfg.TokenStore = store
+
+	client, err := cfg.GetClient(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the revoked access token is refreshed, which rotates the refresh token and saves it
+	_, _, _, err = arubacentral.NewClient(client, s.BaseURL()).ListUsers(context.Background(), arubacentral.NewPaginationVars(1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := store.Load(context.Background())
+	if err != nil || stored == nil {
+		t.Fatalf("no token was stored after refreshing: %v", err)
+	}
+
+	if stored.RefreshToken == refreshToken {
+		t.Fatal("refreshing didn't rotate the refresh token")
+	}
+
+	// restarting with the same configuration continues with the rotated token
+	if got := refreshTokenFlowToken(t, cfg); got.RefreshToken != stored.RefreshToken {
+		t.Errorf("started with refresh token %s, want the stored %s", got.RefreshToken, stored.RefreshToken)
+	}
+
+	// a newly configured refresh token replaces the stored one
+	cfg.AccessToken, cfg.RefreshToken = s.IssueToken()
+	if got := refreshTokenFlowToken(t, cfg); got.RefreshToken != cfg.RefreshToken {
+		t.Errorf("started with refresh token %s, want the newly configured %s", got.RefreshToken, cfg.RefreshToken)
+	}
+
+	// tokens stored by earlier versions carry no origin and are still used
+	legacy := &Token{AccessToken: "legacy-access", RefreshToken: "legacy-refresh"}
+	if err := store.Save(context.Background(), legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := refreshTokenFlowToken(t, cfg); got.RefreshToken != legacy.RefreshToken {
+		t.Errorf("started with refresh token %s, want the stored %s", got.RefreshToken, legacy.RefreshToken)
+	}
+}
@@ -0,0 +1,207 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+)
+
+// userIndex is built once from a full user listing and shared by the builders, so users are listed once per sync
+// and grants come from lookups instead of paging through all users for every group and role.
+// It lives for a single sync: Reset drops it when a sync starts, and a sync resumed from a checkpoint rebuilds it on first use.
+// In MSP mode a separate index is kept for every tenant, keyed by the tenant of the request context.
+type userIndex struct {
+	client arubacentral.Backend
+
+	mu      sync.Mutex
+	tenants map[string]*tenantUsers
+}
+
+// tenantUsers indexes the users of a single tenant.
+type tenantUsers struct {
+	users []arubacentral.User
+	// byGroup holds positions of users scoped to a group explicitly.
+	byGroup map[string][]int
+	// allGroups holds positions of users scoped to all groups.
+	allGroups []int
+	// byRole holds positions of users holding a role, keyed by role resource id.
+	byRole map[string][]int
+	// bySite holds positions of users scoped to a site, keyed by the site id or name used in the scope.
+	bySite map[string][]int
+	// byLabel holds positions of users scoped to a label, keyed by the label id or name used in the scope.
+	byLabel map[string][]int
+}
+
+func newUserIndex(client arubacentral.Backend) *userIndex {
+	return &userIndex{
+		client:  client,
+		tenants: make(map[string]*tenantUsers),
+	}
+}
+
+// Users returns all users, in the order they were listed.
+func (idx *userIndex) Users(ctx context.Context) ([]arubacentral.User, *v2.RateLimitDescription, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	tu, rl, err := idx.load(ctx)
+	if err != nil {
+		return nil, rl, err
+	}
+
+	return tu.users, rl, nil
+}
+
+// UsersInGroup returns users with access to the group, either explicitly or through an all groups scope.
+func (idx *userIndex) UsersInGroup(ctx context.Context, group string) ([]arubacentral.User, *v2.RateLimitDescription, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	tu, rl, err := idx.load(ctx)
+	if err != nil {
+		return nil, rl, err
+	}
+
+	return tu.lookup(tu.byGroup[group], tu.allGroups), rl, nil
+}
+
+// UsersWithRole returns users holding the role in the given application.
+func (idx *userIndex) UsersWithRole(ctx context.Context, appName, roleName string) ([]arubacentral.User, *v2.RateLimitDescription, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	tu, rl, err := idx.load(ctx)
+	if err != nil {
+		return nil, rl, err
+	}
+
+	return tu.lookup(tu.byRole[roleResourceID(appName, roleName)]), rl, nil
+}
+
+// UsersInSite returns users scoped to the site identified by any of the given keys.
+func (idx *userIndex) UsersInSite(ctx context.Context, siteKeys ...string) ([]arubacentral.User, *v2.RateLimitDescription, error) {
+	return idx.usersInScope(ctx, func(tu *tenantUsers) map[string][]int { return tu.bySite }, siteKeys)
+}
+
+// UsersInLabel returns users scoped to the label identified by any of the given keys.
+func (idx *userIndex) UsersInLabel(ctx context.Context, labelKeys ...string) ([]arubacentral.User, *v2.RateLimitDescription, error) {
+	return idx.usersInScope(ctx, func(tu *tenantUsers) map[string][]int { return tu.byLabel }, labelKeys)
+}
+
+// usersInScope returns users found under any of the keys of the index picked by byKey.
+func (idx *userIndex) usersInScope(ctx context.Context, byKey func(*tenantUsers) map[string][]int, keys []string) ([]arubacentral.User, *v2.RateLimitDescription, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	tu, rl, err := idx.load(ctx)
+	if err != nil {
+		return nil, rl, err
+	}
+
+	var positions [][]int
+	for _, key := range keys {
+		positions = append(positions, byKey(tu)[key])
+	}
+
+	return tu.lookup(positions...), rl, nil
+}
+
+// Invalidate drops the index of the context's tenant so it's rebuilt on next use. It must be called after changing users.
+func (idx *userIndex) Invalidate(ctx context.Context) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	delete(idx.tenants, arubacentral.TenantFromContext(ctx))
+}
+
+// Reset drops the indexes of all tenants, so a new sync doesn't see users as they were during the last one.
+func (idx *userIndex) Reset() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.tenants = make(map[string]*tenantUsers)
+}
+
+// lookup returns users at the given positions in listing order, without duplicates.
+func (tu *tenantUsers) lookup(positions ...[]int) []arubacentral.User {
+	seen := make(map[int]bool)
+	var unique []int
+	for _, p := range positions {
+		for _, pos := range p {
+			if !seen[pos] {
+				seen[pos] = true
+				unique = append(unique, pos)
+			}
+		}
+	}
+
+	slices.Sort(unique)
+
+	rv := make([]arubacentral.User, 0, len(unique))
+	for _, pos := range unique {
+		rv = append(rv, tu.users[pos])
+	}
+
+	return rv
+}
+
+// load returns the index of the context's tenant, building it unless it already exists. Callers must hold mu.
+// The rate limit is only returned when the index was built, a cached index made no API call to report on.
+func (idx *userIndex) load(ctx context.Context) (*tenantUsers, *v2.RateLimitDescription, error) {
+	tenantID := arubacentral.TenantFromContext(ctx)
+	if tu, ok := idx.tenants[tenantID]; ok {
+		return tu, nil, nil
+	}
+
+	users, rl, err := arubacentral.ListAll(ctx, idx.client.ListUsers, ResourcesPageSize)
+	if err != nil {
+		return nil, rl, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	byGroup := make(map[string][]int)
+	byRole := make(map[string][]int)
+	bySite := make(map[string][]int)
+	byLabel := make(map[string][]int)
+	var allGroups []int
+	for i, user := range users {
+		for _, app := range user.Applications {
+			for _, info := range app.Info {
+				roleID := roleResourceID(app.Name, info.Role)
+				byRole[roleID] = append(byRole[roleID], i)
+
+				for _, site := range info.Scope.Sites {
+					bySite[site] = append(bySite[site], i)
+				}
+
+				for _, label := range info.Scope.Labels {
+					byLabel[label] = append(byLabel[label], i)
+				}
+
+				if info.Scope.HasAllGroups() {
+					allGroups = append(allGroups, i)
+					continue
+				}
+
+				for _, group := range info.Scope.Groups {
+					byGroup[group] = append(byGroup[group], i)
+				}
+			}
+		}
+	}
+
+	tu := &tenantUsers{
+		users:     users,
+		byGroup:   byGroup,
+		allGroups: allGroups,
+		byRole:    byRole,
+		bySite:    bySite,
+		byLabel:   byLabel,
+	}
+	idx.tenants[tenantID] = tu
+
+	return tu, rl, nil
+}
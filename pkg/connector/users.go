@@ -3,20 +3,31 @@ package connector
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/conductorone/baton-aruba-central/pkg/arubacentral"
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
 	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
 	"github.com/conductorone/baton-sdk/pkg/pagination"
 	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type userBuilder struct {
-	client       *arubacentral.Client
+	client       arubacentral.Backend
 	resourceType *v2.ResourceType
+	users        *userIndex
+	tenancy      tenancy
+
+	// connectorUsername is the user owning the connector's OAuth token, if known.
+	connectorUsername string
 }
 
-func userResource(user *arubacentral.User) (*v2.Resource, error) {
+func userResource(scope tenantScope, user *arubacentral.User) (*v2.Resource, error) {
 	profile := map[string]interface{}{
 		"login":      user.Username,
 		"first_name": user.Name.First,
@@ -27,10 +38,11 @@ func userResource(user *arubacentral.User) (*v2.Resource, error) {
 	resource, err := rs.NewUserResource(
 		fullName,
 		userResourceType,
-		user.Username,
+		scope.resourceID(user.Username),
 		[]rs.UserTraitOption{
 			rs.WithUserProfile(profile),
 		},
+		scope.resourceOptions()...,
 	)
 	if err != nil {
 		return nil, err
@@ -45,21 +57,31 @@ func (u *userBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
 
 // List returns all the users from the database as resource objects.
 // Users include a UserTrait because they are the 'shape' of a standard user.
-func (u *userBuilder) List(ctx context.Context, _ *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+// In MSP mode users are listed under their tenant.
+func (u *userBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	scope, ok := u.tenancy.listScope(parentResourceID)
+	if !ok {
+		return nil, "", nil, nil
+	}
+
+	ctx = scope.context(ctx)
 	bag, offset, err := parsePageToken(pToken.Token, &v2.ResourceId{ResourceType: u.resourceType.Id})
 	if err != nil {
 		return nil, "", nil, fmt.Errorf("failed to parse page token: %w", err)
 	}
 
-	pgVars := arubacentral.NewPaginationVars(ResourcesPageSize, offset)
-	users, total, rl, err := u.client.ListUsers(ctx, pgVars)
+	// users are paged from the user index, which the grants of other resources need anyway
+	users, rl, err := u.users.Users(ctx)
 	if err != nil {
-		return nil, "", annotations.New(rl), fmt.Errorf("failed to list users: %w", err)
+		return nil, "", rateLimitAnnotations(rl), err
 	}
 
+	start := min(int(offset), len(users))
+	end := min(start+int(ResourcesPageSize), len(users))
+
 	var rv []*v2.Resource
-	for _, user := range users {
-		ur, err := userResource(&user) // #nosec G601
+	for _, user := range users[start:end] {
+		ur, err := userResource(scope, &user) // #nosec G601
 		if err != nil {
 			return nil, "", nil, fmt.Errorf("failed to create user resource: %w", err)
 		}
@@ -67,13 +89,17 @@ func (u *userBuilder) List(ctx context.Context, _ *v2.ResourceId, pToken *pagina
 		rv = append(rv, ur)
 	}
 
-	nextPage := prepareNextToken(offset, total)
+	var nextPage string
+	if end < len(users) {
+		nextPage = fmt.Sprint(end)
+	}
+
 	next, err := bag.NextToken(nextPage)
 	if err != nil {
 		return nil, "", nil, fmt.Errorf("failed to prepare next page token: %w", err)
 	}
 
-	return rv, next, annotations.New(rl), nil
+	return rv, next, rateLimitAnnotations(rl), nil
 }
 
 // Entitlements always returns an empty slice for users.
@@ -86,9 +112,154 @@ func (u *userBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken
 	return nil, "", nil, nil
 }
 
-func newUserBuilder(client *arubacentral.Client) *userBuilder {
+// CreateAccount creates a new user in Aruba Central.
+// Aruba Central sends the new user an invitation to set up their password, so no credentials are returned.
+// Initial role and group scope are read from the "role" and "groups" profile fields.
+// In MSP mode the tenant to create the user in is read from the "tenant_id" profile field.
+func (u *userBuilder) CreateAccount(
+	ctx context.Context,
+	accountInfo *v2.AccountInfo,
+	_ *v2.CredentialOptions,
+) (connectorbuilder.CreateAccountResponse, []*v2.PlaintextData, annotations.Annotations, error) {
+	user, err := userFromAccountInfo(accountInfo)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var scope tenantScope
+	if u.tenancy.msp {
+		tenantID, _ := rs.GetProfileStringValue(accountInfo.GetProfile(), "tenant_id")
+		if tenantID == "" {
+			return nil, nil, nil, status.Error(codes.InvalidArgument, "baton-aruba-central: tenant_id is required to create a user in MSP mode")
+		}
+
+		scope = u.tenancy.scope(tenantID)
+		ctx = scope.context(ctx)
+	}
+
+	rl, err := u.client.CreateUser(ctx, user)
+	u.users.Invalidate(ctx)
+	if err != nil {
+		return nil, nil, annotations.New(rl), fmt.Errorf("baton-aruba-central: failed to create user %s: %w", user.Username, err)
+	}
+
+	resource, err := userResource(scope, user)
+	if err != nil {
+		return nil, nil, annotations.New(rl), fmt.Errorf("baton-aruba-central: failed to create user resource: %w", err)
+	}
+
+	return &v2.CreateAccountResponse_SuccessResult{
+		Resource:              resource,
+		IsCreateAccountResult: true,
+	}, nil, annotations.New(rl), nil
+}
+
+// userFromAccountInfo maps account info onto an Aruba Central user.
+// Usernames in Aruba Central are email addresses, so login falls back to the primary email.
+func userFromAccountInfo(accountInfo *v2.AccountInfo) (*arubacentral.User, error) {
+	profile := accountInfo.GetProfile()
+
+	username := accountInfo.GetLogin()
+	if username == "" {
+		for _, email := range accountInfo.GetEmails() {
+			if username == "" || email.GetIsPrimary() {
+				username = email.GetAddress()
+			}
+		}
+	}
+	if username == "" {
+		username, _ = rs.GetProfileStringValue(profile, "email")
+	}
+	if username == "" {
+		return nil, status.Error(codes.InvalidArgument, "baton-aruba-central: login or email is required to create a user")
+	}
+
+	firstName, _ := rs.GetProfileStringValue(profile, "first_name")
+	lastName, _ := rs.GetProfileStringValue(profile, "last_name")
+
+	role, _ := rs.GetProfileStringValue(profile, "role")
+	if role == "" {
+		return nil, status.Error(codes.InvalidArgument, "baton-aruba-central: role is required to create a user")
+	}
+
+	groups := getProfileStringSlice(profile, "groups")
+	if len(groups) == 0 {
+		return nil, status.Errorf(
+			codes.InvalidArgument,
+			"baton-aruba-central: groups are required to create a user, use %q to scope the user to all groups",
+			arubacentral.AllGroupsScope,
+		)
+	}
+
+	return &arubacentral.User{
+		Username: username,
+		Name: arubacentral.UserName{
+			First: firstName,
+			Last:  lastName,
+		},
+		Applications: []arubacentral.UserApplication{
+			{
+				Name: arubacentral.ArubaCentralApp,
+				Info: []arubacentral.UserRoleInfo{
+					{
+						Role:  role,
+						Scope: arubacentral.UserScope{Groups: groups},
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// Create is not supported for users, they are created through account provisioning.
+func (u *userBuilder) Create(_ context.Context, _ *v2.Resource) (*v2.Resource, annotations.Annotations, error) {
+	return nil, nil, status.Error(codes.Unimplemented, "baton-aruba-central: users are created through account provisioning")
+}
+
+// Delete deletes the user from Aruba Central.
+// Users that no longer exist are treated as deleted so offboarding can be safely repeated.
+func (u *userBuilder) Delete(ctx context.Context, resourceId *v2.ResourceId) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	if resourceId.ResourceType != userResourceType.Id {
+		return nil, fmt.Errorf("baton-aruba-central: unexpected resource type %s for user deletion", resourceId.ResourceType)
+	}
+
+	scope, username, err := u.tenancy.parseResourceID(resourceId.Resource)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = scope.context(ctx)
+	if u.connectorUsername != "" && strings.EqualFold(username, u.connectorUsername) {
+		return nil, status.Errorf(
+			codes.FailedPrecondition,
+			"baton-aruba-central: refusing to delete user %s because it owns the connector's OAuth token",
+			username,
+		)
+	}
+
+	rl, err := u.client.DeleteUser(ctx, username)
+	u.users.Invalidate(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			l.Info("baton-aruba-central: user already deleted", zap.String("user", username))
+
+			return annotations.New(rl), nil
+		}
+
+		return annotations.New(rl), fmt.Errorf("baton-aruba-central: failed to delete user %s: %w", username, err)
+	}
+
+	return annotations.New(rl), nil
+}
+
+func newUserBuilder(client arubacentral.Backend, users *userIndex, tenancy tenancy, connectorUsername string) *userBuilder {
 	return &userBuilder{
-		client:       client,
-		resourceType: userResourceType,
+		client:            client,
+		resourceType:      userResourceType,
+		users:             users,
+		tenancy:           tenancy,
+		connectorUsername: connectorUsername,
 	}
 }